@@ -0,0 +1,275 @@
+package sampler
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+	s "github.com/invertedv/chutils/sql"
+)
+
+// Version is the version of the package recorded in audit records.  It is read from the build info of the binary and
+// is "devel" if the package isn't built as a versioned module.
+var Version = version()
+
+// auditColumns are the columns of the audit table.
+const auditColumns = `built DateTime,
+  version String,
+  query String,
+  queryHash String,
+  fields Array(String),
+  targetTotal Int64,
+  sampleCap Float64,
+  minCount Int64,
+  seed Int64,
+  expCaptured Int64,
+  actCaptured Int64,
+  makeQuery String,
+  sampleTable String,
+  stratTable String,
+  plan String,
+  strata Array(String),
+  expected Array(Float64),
+  actual Array(UInt64)`
+
+// StratumCount is the expected and actual count of a stratum of sampleTable.
+type StratumCount struct {
+	Stratum  string  // label of the stratum
+	Expected float64 // count expected from the sampling rate
+	Actual   uint64  // count in sampleTable
+}
+
+// version returns the version of the module of the package from the build info.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+
+	path := reflect.TypeOf(Generator{}).PkgPath()
+	ver := info.Main.Version
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			ver = dep.Version
+			if dep.Replace != nil && dep.Replace.Version != "" {
+				ver = dep.Replace.Version
+			}
+		}
+	}
+
+	if ver == "" || ver == "(devel)" {
+		return "devel"
+	}
+
+	return ver
+}
+
+// AuditTable returns (and optionally sets) the audit table.  If set, MakeTable adds a row to the table recording how
+// sampleTable was built.  The table is created if it doesn't exist.
+// The value is not updated if table is empty.
+func (gn *Generator) AuditTable(table string) string {
+	if table != "" {
+		gn.auditTable = table
+	}
+
+	return gn.auditTable
+}
+
+// audit adds the record of the sampleTable build to the audit table.
+func (gn *Generator) audit() error {
+	create, insert, e := gn.auditQueries(time.Now())
+	if e != nil {
+		return e
+	}
+
+	if e := gn.conn.Execute(create); e != nil {
+		return e
+	}
+
+	return gn.conn.Execute(insert)
+}
+
+// auditQueries returns the queries that create the audit table and insert the record of the build at built.
+func (gn *Generator) auditQueries(built time.Time) (create, insert string, err error) {
+	plan, e := json.Marshal(gn)
+	if e != nil {
+		return "", "", e
+	}
+
+	fields := make([]string, len(gn.strats.fields))
+	for ind, fld := range gn.strats.fields {
+		fields[ind] = literal(fld)
+	}
+
+	counts := gn.StratumCounts()
+	strata, exp, act := make([]string, len(counts)), make([]string, len(counts)), make([]string, len(counts))
+	for ind, cnt := range counts {
+		strata[ind], exp[ind], act[ind] = literal(cnt.Stratum), floatLiteral(cnt.Expected), fmt.Sprintf("%d", cnt.Actual)
+	}
+
+	vals := []string{
+		fmt.Sprintf("toDateTime('%s')", built.Format("2006-01-02 15:04:05")),
+		literal(Version),
+		literal(gn.Query),
+		literal(fmt.Sprintf("%x", sha256.Sum256([]byte(gn.Query)))),
+		fmt.Sprintf("[%s]", strings.Join(fields, ",")),
+		fmt.Sprintf("%d", gn.targetTotal),
+		floatLiteral(gn.sampleCap),
+		fmt.Sprintf("%d", gn.minCount),
+		fmt.Sprintf("%d", gn.seed),
+		fmt.Sprintf("%d", gn.expCaptured),
+		fmt.Sprintf("%d", gn.actCaptured),
+		literal(gn.makeQuery),
+		literal(gn.sampleTable),
+		literal(gn.stratTable),
+		literal(string(plan)),
+		fmt.Sprintf("[%s]", strings.Join(strata, ",")),
+		fmt.Sprintf("[%s]", strings.Join(exp, ",")),
+		fmt.Sprintf("[%s]", strings.Join(act, ",")),
+	}
+
+	create = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n) ENGINE=MergeTree()\nORDER BY (sampleTable, built)",
+		gn.auditTable, auditColumns)
+	insert = fmt.Sprintf("INSERT INTO %s VALUES (%s)", gn.auditTable, strings.Join(vals, ", "))
+
+	return create, insert, nil
+}
+
+// floatLiteral returns x as a ClickHouse Float64 literal.  nan and +/-inf are nan, inf and -inf.
+func floatLiteral(x float64) string {
+	switch {
+	case math.IsNaN(x):
+		return "nan"
+	case math.IsInf(x, 1):
+		return "inf"
+	case math.IsInf(x, -1):
+		return "-inf"
+	default:
+		return fmt.Sprintf("%v", x)
+	}
+}
+
+// StratumCounts returns the expected and actual count of each stratum of sampleTable.  MakeTable must be run first.
+func (gn *Generator) StratumCounts() []StratumCount {
+	if gn.strats == nil || gn.sampleStrats == nil {
+		return nil
+	}
+
+	act := make(map[string]uint64)
+	for ind, key := range gn.sampleStrats.keys {
+		act[keyString(key)] = gn.sampleStrats.count[ind]
+	}
+
+	counts := make([]StratumCount, len(gn.strats.keys))
+	for ind, key := range gn.strats.keys {
+		counts[ind] = StratumCount{
+			Stratum:  gn.strats.label(key),
+			Expected: gn.sampleRate[ind] * float64(gn.strats.count[ind]),
+			Actual:   act[keyString(key)],
+		}
+	}
+
+	return counts
+}
+
+// CheckAudit checks the counts of each stratum of sampleTable against those recorded in the audit table when it was
+// built.  The Generator must come from FromAudit and MakeTable must be run since, so CheckAudit verifies that the build
+// has been reproduced.  Only sampling with a seed or HashSample is reproducible.
+func (gn *Generator) CheckAudit() error {
+	if gn.audited == nil {
+		return fmt.Errorf("(*Generator) CheckAudit: no audit record, use FromAudit")
+	}
+
+	counts := gn.StratumCounts()
+	if len(counts) != len(gn.audited) {
+		return fmt.Errorf("(*Generator) CheckAudit: %d strata, audit has %d", len(counts), len(gn.audited))
+	}
+
+	for ind, cnt := range counts {
+		aud := gn.audited[ind]
+		if cnt.Stratum != aud.Stratum {
+			return fmt.Errorf("(*Generator) CheckAudit: stratum %s, audit has %s", cnt.Stratum, aud.Stratum)
+		}
+
+		if math.Abs(cnt.Expected-aud.Expected) > 1e-6*math.Max(1.0, aud.Expected) {
+			return fmt.Errorf("(*Generator) CheckAudit: stratum %s expected %v rows, audit has %v", cnt.Stratum,
+				cnt.Expected, aud.Expected)
+		}
+
+		if cnt.Actual != aud.Actual {
+			return fmt.Errorf("(*Generator) CheckAudit: stratum %s has %d rows, audit has %d", cnt.Stratum,
+				cnt.Actual, aud.Actual)
+		}
+	}
+
+	return nil
+}
+
+// FromAudit returns the *Generator that built sampleTable as recorded in auditTable.  If sampleTable was built more
+// than once, the latest build is returned.  The Generator has the plan, so MakeTable can rebuild sampleTable without
+// running CalcRates, and the recorded counts of each stratum, so CheckAudit can check the rebuild.
+func FromAudit(auditTable, sampleTable string, conn *chutils.Connect) (*Generator, error) {
+	qry := fmt.Sprintf("SELECT plan FROM %s WHERE sampleTable = %s ORDER BY built DESC LIMIT 1", auditTable, literal(sampleTable))
+	rdr := s.NewReader(qry, conn)
+
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		return nil, e
+	}
+
+	rows, _, e := rdr.Read(0, false)
+	if e != nil {
+		return nil, e
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("FromAudit: no record of %s in %s", sampleTable, auditTable)
+	}
+
+	gn := &Generator{}
+	if e := json.Unmarshal([]byte(rows[0][0].(string)), gn); e != nil {
+		return nil, e
+	}
+	gn.SetConnect(conn)
+
+	if gn.audited, e = auditCounts(auditTable, sampleTable, conn); e != nil {
+		return nil, e
+	}
+
+	return gn, nil
+}
+
+// auditCounts returns the counts of each stratum of the latest build of sampleTable recorded in auditTable.
+func auditCounts(auditTable, sampleTable string, conn *chutils.Connect) ([]StratumCount, error) {
+	qry := fmt.Sprintf(`SELECT s, e, a FROM (SELECT strata, expected, actual FROM %s WHERE sampleTable = %s
+ORDER BY built DESC LIMIT 1) ARRAY JOIN strata AS s, expected AS e, actual AS a`, auditTable, literal(sampleTable))
+	rdr := s.NewReader(qry, conn)
+
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		return nil, e
+	}
+
+	rows, _, e := rdr.Read(0, false)
+	if e != nil {
+		return nil, e
+	}
+
+	counts := make([]StratumCount, len(rows))
+	for ind, row := range rows {
+		stratum, ok1 := row[0].(string)
+		exp, ok2 := row[1].(float64)
+		act, ok3 := row[2].(uint64)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("FromAudit: bad stratum counts in %s: %v", auditTable, row)
+		}
+
+		counts[ind] = StratumCount{Stratum: stratum, Expected: exp, Actual: act}
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,66 @@
+package sampler
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_auditQueries(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM bk.loans WHERE state = 'CA'", "tmp.sample", "tmp.strat", 2000, false, nil)
+	gn.strats = &Strat{fields: []string{"state", "fico"}}
+	assert.Equal(t, "tmp.audit", gn.AuditTable("tmp.audit"))
+	assert.Equal(t, "tmp.audit", gn.AuditTable(""))
+
+	create, insert, e := gn.auditQueries(time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, e)
+	assert.True(t, strings.HasPrefix(create, "CREATE TABLE IF NOT EXISTS tmp.audit ("))
+	assert.True(t, strings.HasPrefix(insert, "INSERT INTO tmp.audit VALUES (toDateTime('2023-03-01 12:00:00'), "+
+		literal(Version)+`, 'SELECT * FROM bk.loans WHERE state = \'CA\''`))
+	assert.Contains(t, insert, "['state','fico'], 2000, 1, 0, 0")
+	assert.NotEqual(t, "", Version)
+}
+
+func TestGenerator_StratumCounts(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM bk.loans", "tmp.sample", "tmp.strat", 400, false, nil)
+	gn.AuditTable("tmp.audit")
+	gn.strats = &Strat{fields: []string{"state"}, keys: [][]any{{"CA"}, {"NY"}}, count: []uint64{3000, 100}}
+	gn.sampleStrats = &Strat{fields: []string{"state"}, keys: [][]any{{"NY"}, {"CA"}}, count: []uint64{99, 310}}
+	gn.sampleRate = []float64{0.1, 1.0}
+
+	exp := []StratumCount{{Stratum: "CA", Expected: 300, Actual: 310}, {Stratum: "NY", Expected: 100, Actual: 99}}
+	assert.Equal(t, exp, gn.StratumCounts())
+
+	_, insert, e := gn.auditQueries(time.Now())
+	assert.Nil(t, e)
+	assert.True(t, strings.HasSuffix(insert, "['CA','NY'], [300,100], [310,99])"))
+
+	assert.NotNil(t, gn.CheckAudit())
+	gn.audited = exp
+	assert.Nil(t, gn.CheckAudit())
+	gn.audited[1].Actual = 100
+	assert.NotNil(t, gn.CheckAudit())
+}
+
+func TestGenerator_auditQueriesNaN(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM bk.loans", "tmp.sample", "tmp.strat", 400, false, nil)
+	gn.AuditTable("tmp.audit")
+	gn.Measure("avg(upb)", false)
+	gn.strats = &Strat{fields: []string{"state"}, keys: [][]any{{"CA"}, {"NY"}, {"WY"}}, count: []uint64{3000, 100, 10},
+		measure: "avg(upb)", measures: []float64{1, math.NaN(), 2},
+		summaries: []*Summary{{Column: "fico", Stat: StatMean, Values: []float64{700, 710, math.NaN()}}}}
+	gn.sampleStrats = &Strat{fields: []string{"state"}, keys: [][]any{{"CA"}, {"NY"}}, count: []uint64{310, 99}}
+	gn.sampleRate = []float64{0.1, math.NaN(), math.Inf(1)}
+
+	// the plan has nan values, which are null in JSON, and the expected counts are nan and inf
+	_, insert, e := gn.auditQueries(time.Now())
+	assert.Nil(t, e)
+	assert.Contains(t, insert, `"measures":[1,null,2]`)
+	assert.True(t, strings.HasSuffix(insert, "['CA','NY','WY'], [300,nan,inf], [310,99,0])"))
+
+	assert.Equal(t, "-inf", floatLiteral(math.Inf(-1)))
+	assert.Equal(t, "0.25", floatLiteral(0.25))
+}
@@ -0,0 +1,126 @@
+package sampler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// BalanceClasses samples to a class ratio on the label column rather than balancing the strata.  The label is added
+// as the last strat field to any others.  Within each stratum of the other fields, the classes are sampled so that
+// their sizes are in the proportions of ratio, where ratio[k] is the relative size of the (k+1)th smallest class.
+// For instance, BalanceClasses("default", 1, 3) gives three rows of the majority class for each row of the minority
+// class.  If ratio has fewer entries than there are classes, the last entry is used for the rest; if ratio is empty,
+// the classes are sampled equally.
+//
+// The minority class is sampled at sampleCap and the others are undersampled to the ratio.  A class that doesn't have
+// the rows to meet the ratio is sampled at sampleCap.  Strata with a single class have no ratio to meet, so they're
+// sampled at the pooled rate of the majority classes of the other strata (sampleCap if there are none).  If
+// targetTotal is positive and the sample would be larger than it, all the rates are scaled down.
+//
+// Sparse strata are collapsed on the other fields only, so a rare class keeps its label rather than being merged into
+// Other with the other classes.
+//
+// sampleTable has a column, weight, which is 1/(sampling rate), to recover population rates.  Class balancing can't
+// be used with a measure that's balanced or with TopUp.
+// Calling BalanceClasses with an empty label turns off class balancing.
+func (gn *Generator) BalanceClasses(label string, ratio ...float64) error {
+	if label == "" {
+		gn.classLabel, gn.classRatio = "", nil
+		gn.reset()
+
+		return nil
+	}
+
+	for _, r := range ratio {
+		if r <= 0.0 {
+			return fmt.Errorf("(*Generator) BalanceClasses: ratio must be positive")
+		}
+	}
+
+	gn.classLabel, gn.classRatio = label, ratio
+	gn.reset()
+
+	return nil
+}
+
+// classFields returns fields with the class label appended, if it's not there already.
+func (gn *Generator) classFields(fields []string) []string {
+	if len(fields) > 0 && fields[len(fields)-1] == gn.classLabel {
+		return fields
+	}
+
+	return append(append([]string{}, fields...), gn.classLabel)
+}
+
+// classRates returns the sampling rates of the strata that give the class ratio within each stratum of the fields
+// other than the label.
+func (gn *Generator) classRates() []float64 {
+	// group the strata by the values of the fields other than the label
+	groups := make(map[string][]int)
+	order := make([]string, 0)
+	for ind, key := range gn.strats.keys {
+		ks := keyString(key[:len(key)-1])
+		if _, ok := groups[ks]; !ok {
+			order = append(order, ks)
+		}
+		groups[ks] = append(groups[ks], ind)
+	}
+
+	rates := make([]float64, len(gn.strats.keys))
+	total, majRows, majSample := 0.0, 0.0, 0.0
+	singles := make([]int, 0)
+	for _, ks := range order {
+		grp := groups[ks]
+		if len(grp) == 1 {
+			singles = append(singles, grp[0])
+			continue
+		}
+
+		sort.SliceStable(grp, func(i, j int) bool { return gn.strats.count[grp[i]] < gn.strats.count[grp[j]] })
+
+		// the minority class is sampled at sampleCap, unit is the sample of a class with ratio 1
+		unit := gn.sampleCap * float64(gn.strats.count[grp[0]]) / gn.ratio(0)
+		for k, ind := range grp {
+			if gn.strats.count[ind] == 0 {
+				continue
+			}
+			rates[ind] = math.Min(gn.ratio(k)*unit/float64(gn.strats.count[ind]), gn.sampleCap)
+			total += rates[ind] * float64(gn.strats.count[ind])
+
+			if k > 0 {
+				majRows += float64(gn.strats.count[ind])
+				majSample += rates[ind] * float64(gn.strats.count[ind])
+			}
+		}
+	}
+
+	// strata with a single class are sampled at the pooled rate of the majority classes
+	majRate := gn.sampleCap
+	if majRows > 0 {
+		majRate = majSample / majRows
+	}
+
+	for _, ind := range singles {
+		rates[ind] = majRate
+		total += rates[ind] * float64(gn.strats.count[ind])
+	}
+
+	if gn.targetTotal > 0 && total > float64(gn.targetTotal) {
+		scale := float64(gn.targetTotal) / total
+		for ind := range rates {
+			rates[ind] *= scale
+		}
+	}
+
+	return rates
+}
+
+// ratio returns the relative size of the (k+1)th smallest class.
+func (gn *Generator) ratio(k int) float64 {
+	if len(gn.classRatio) == 0 {
+		return 1.0
+	}
+
+	return gn.classRatio[Min(k, len(gn.classRatio)-1)]
+}
@@ -0,0 +1,81 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_classRates(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 0, false, nil)
+	assert.NotNil(t, gn.BalanceClasses("default", 1, 0))
+	assert.Nil(t, gn.BalanceClasses("default", 1, 3))
+	assert.Equal(t, []string{"state", "default"}, gn.classFields([]string{"state"}))
+	assert.Equal(t, []string{"state", "default"}, gn.classFields([]string{"state", "default"}))
+
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.strats.fields = []string{"state", "default"}
+	gn.strats.keys = [][]any{{"TX", int32(0)}, {"TX", int32(1)}, {"VT", int32(0)}, {"VT", int32(1)}}
+	gn.strats.count = []uint64{10000, 100, 1000, 500}
+
+	// all the minority rows are kept and the majority is sampled to three times that, if it can be
+	rates := gn.classRates()
+	assert.InDeltaSlice(t, []float64{0.03, 1.0, 1.0, 1.0}, rates, 0.0001)
+
+	// with a cap, the minority class is sampled at the cap
+	gn.sampleCap = 0.5
+	rates = gn.classRates()
+	assert.InDeltaSlice(t, []float64{0.015, 0.5, 0.5, 0.5}, rates, 0.0001)
+
+	// targetTotal scales the rates down
+	gn.sampleCap, gn.targetTotal = 1.0, 950
+	rates = gn.classRates()
+	assert.InDeltaSlice(t, []float64{0.015, 0.5, 0.5, 0.5}, rates, 0.0001)
+
+	qry := gn.sampleQuery(gn.Query)
+	assert.Contains(t, qry, "1.0 / b.sampleRate AS weight")
+}
+
+func TestGenerator_classRatesSingle(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 0, false, nil)
+	assert.Nil(t, gn.BalanceClasses("default", 1, 3))
+
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.strats.fields = []string{"state", "default"}
+	gn.strats.keys = [][]any{{"TX", int32(0)}, {"TX", int32(1)}, {"VT", int32(0)}, {"VT", int32(1)}, {"WY", int32(0)}}
+	gn.strats.count = []uint64{10000, 100, 1000, 500, 600}
+
+	// WY has only one class, so it's sampled at the rate of the majority classes: (300 + 1000) / (10000 + 1000)
+	rates := gn.classRates()
+	assert.InDeltaSlice(t, []float64{0.03, 1.0, 1.0, 1.0, 1300.0 / 11000.0}, rates, 0.0001)
+
+	// with no stratum having more than one class, they're sampled at the cap
+	gn.strats.keys, gn.strats.count = [][]any{{"WY", int32(0)}}, []uint64{600}
+	assert.InDeltaSlice(t, []float64{1.0}, gn.classRates(), 0.0001)
+}
+
+func TestGenerator_classConflicts(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	assert.Nil(t, gn.BalanceClasses("default"))
+	gn.Measure("sum(upb)", true)
+	assert.NotNil(t, gn.CalcRates("state"))
+
+	gn.Measure("sum(upb)", false)
+	assert.NotNil(t, gn.TopUp(0.1, "loanId"))
+	assert.Nil(t, gn.TopUp(0.0))
+
+	gn.topUpTol = 0.1
+	assert.NotNil(t, gn.CalcRates("state"))
+}
+
+func TestStrat_collapseKeepLast(t *testing.T) {
+	strt := &Strat{fields: []string{"vintage", "default"}, minCount: 50, collapse: CollapseNeighbor, keepLast: true}
+	strt.rawKeys = [][]any{{int32(2020), int32(0)}, {int32(2020), int32(1)}, {int32(2021), int32(0)}, {int32(2021), int32(1)}}
+	strt.rawCount = []uint64{500, 5, 300, 3}
+	strt.collapseSparse()
+
+	// the defaults are rare, but they're merged across vintages rather than into the non-defaults
+	assert.Equal(t, [][]any{{"2020", int32(0)}, {"2021", int32(0)}, {Other, int32(1)}}, strt.keys)
+	assert.Equal(t, []uint64{500, 300, 8}, strt.count)
+	assert.Equal(t, []int{0, 2, 1, 2}, strt.group)
+}
@@ -0,0 +1,343 @@
+// Command sampler produces strats and stratified samples of ClickHouse data from the command line.
+//
+// Usage:
+//
+//	sampler <command> [flags]
+//
+// The commands are:
+//
+//	strat   print or export the strats of a query
+//	rates   calculate the sampling rates of a spec and print the plan; -explain prints an outline of the DDL and the queries
+//	sample  create the sample table of a spec or plan
+//	report  print the comparison of a sample to its population or write an HTML report
+//
+// The connection flags -host, -user and -pw default to the environment variables host, user and pw.
+// Run "sampler <command> -h" for the flags of a command.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/sampler"
+)
+
+const usage = `usage: sampler <command> [flags]
+
+commands:
+  strat   print or export the strats of a query
+  rates   calculate the sampling rates of a spec and print the plan
+  sample  create the sample table of a spec or plan
+  report  print the comparison of a sample to its population or write an HTML report
+`
+
+func main() {
+	if e := run(os.Args[1:], os.Stdout); e != nil {
+		fmt.Fprintln(os.Stderr, e)
+		os.Exit(1)
+	}
+}
+
+// run runs the command given by args, writing output to w.
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	cmds := map[string]func([]string, io.Writer) error{
+		"strat":  strat,
+		"rates":  rates,
+		"sample": sample,
+		"report": report,
+	}
+
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %s\n%s", args[0], usage)
+	}
+
+	return cmd(args[1:], w)
+}
+
+// connFlags are the flags for the DB connection.
+type connFlags struct {
+	host, user, pw *string
+}
+
+func newConnFlags(fs *flag.FlagSet) *connFlags {
+	return &connFlags{
+		host: fs.String("host", os.Getenv("host"), "ClickHouse host (env: host)"),
+		user: fs.String("user", os.Getenv("user"), "ClickHouse user (env: user)"),
+		pw:   fs.String("pw", os.Getenv("pw"), "ClickHouse password (env: pw)"),
+	}
+}
+
+func (cf *connFlags) connect() (*chutils.Connect, error) {
+	return chutils.NewConnect(*cf.host, *cf.user, *cf.pw, nil)
+}
+
+// output returns the writer for the output file, which is w if file is empty.
+func output(file string, w io.Writer) (io.WriteCloser, error) {
+	if file == "" {
+		return nopCloser{w}, nil
+	}
+
+	return os.Create(file)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func strat(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("strat", flag.ContinueOnError)
+	cf := newConnFlags(fs)
+	query := fs.String("query", "", "query that fetches the data (required)")
+	fields := fs.String("fields", "", "comma-separated strat fields (required)")
+	minCount := fs.Int("minCount", 0, "minimum # of rows for a stratum")
+	sortByCount := fs.Bool("sortByCount", false, "sort strata by descending count")
+	format := fs.String("format", "text", "output format: text, csv, json, markdown or parquet")
+	out := fs.String("out", "", "output file (default: stdout)")
+
+	if e := fs.Parse(args); e != nil {
+		return e
+	}
+
+	if *query == "" || *fields == "" {
+		return fmt.Errorf("strat: -query and -fields are required")
+	}
+
+	write, e := stratWriter(*format)
+	if e != nil {
+		return e
+	}
+
+	conn, e := cf.connect()
+	if e != nil {
+		return e
+	}
+	defer func() { _ = conn.Close() }()
+
+	strt := sampler.NewStrat(*query, conn, *sortByCount)
+	strt.MinCount(*minCount)
+	if e := strt.Make(strings.Split(*fields, ",")...); e != nil {
+		return e
+	}
+
+	f, e := output(*out, w)
+	if e != nil {
+		return e
+	}
+
+	if e := write(strt, f); e != nil {
+		_ = f.Close()
+		return e
+	}
+
+	return f.Close()
+}
+
+// stratWriter returns the function that writes a strat in format.
+func stratWriter(format string) (func(*sampler.Strat, io.Writer) error, error) {
+	switch format {
+	case "text":
+		return func(strt *sampler.Strat, w io.Writer) error {
+			_, e := fmt.Fprintln(w, strt)
+			return e
+		}, nil
+	case "csv":
+		return (*sampler.Strat).WriteCSV, nil
+	case "json":
+		return (*sampler.Strat).WriteJSON, nil
+	case "markdown":
+		return (*sampler.Strat).WriteMarkdown, nil
+	case "parquet":
+		return (*sampler.Strat).WriteParquet, nil
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+}
+
+// generator returns the *Generator given by the spec file or, if spec is empty, the plan file.
+func generator(spec, plan string, conn *chutils.Connect) (*sampler.Generator, error) {
+	switch {
+	case spec != "":
+		data, e := os.ReadFile(spec)
+		if e != nil {
+			return nil, e
+		}
+
+		sp, e := sampler.ParseSpec(data)
+		if e != nil {
+			return nil, e
+		}
+
+		return sp.Generator(conn)
+	case plan != "":
+		data, e := os.ReadFile(plan)
+		if e != nil {
+			return nil, e
+		}
+
+		gn := &sampler.Generator{}
+		if e := json.Unmarshal(data, gn); e != nil {
+			return nil, e
+		}
+		gn.SetConnect(conn)
+
+		return gn, nil
+	default:
+		return nil, fmt.Errorf("one of -spec or -plan is required")
+	}
+}
+
+// savePlan writes gn as JSON to file, if file isn't empty.
+func savePlan(gn *sampler.Generator, file string) error {
+	if file == "" {
+		return nil
+	}
+
+	data, e := json.MarshalIndent(gn, "", "  ")
+	if e != nil {
+		return e
+	}
+
+	return os.WriteFile(file, data, 0o644)
+}
+
+func rates(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("rates", flag.ContinueOnError)
+	cf := newConnFlags(fs)
+	spec := fs.String("spec", "", "YAML/JSON sampling spec (required)")
+	save := fs.String("save", "", "file to save the plan to as JSON")
+	explain := fs.Bool("explain", false, "print an outline of the DDL, the sampling query and the rate calculation of the sample")
+
+	if e := fs.Parse(args); e != nil {
+		return e
+	}
+
+	if *spec == "" {
+		return fmt.Errorf("rates: -spec is required")
+	}
+
+	conn, e := cf.connect()
+	if e != nil {
+		return e
+	}
+	defer func() { _ = conn.Close() }()
+
+	gn, e := generator(*spec, "", conn)
+	if e != nil {
+		return e
+	}
+
+	if e := gn.CalcRates(); e != nil {
+		return e
+	}
+
+	if _, e := fmt.Fprintln(w, gn); e != nil {
+		return e
+	}
+
+	if *explain {
+		plan, e := gn.Explain()
+		if e != nil {
+			return e
+		}
+
+		if _, e := fmt.Fprintln(w, plan); e != nil {
+			return e
+		}
+	}
+
+	return savePlan(gn, *save)
+}
+
+func sample(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("sample", flag.ContinueOnError)
+	cf := newConnFlags(fs)
+	spec := fs.String("spec", "", "YAML/JSON sampling spec")
+	plan := fs.String("plan", "", "plan saved by rates, used if -spec is not given")
+	save := fs.String("save", "", "file to save the plan, including the sample strats, to as JSON")
+	timeOut := fs.Int64("timeout", 0, "query timeout in minutes (0 is no timeout)")
+	audit := fs.String("audit", "", "audit table to record the build in")
+
+	if e := fs.Parse(args); e != nil {
+		return e
+	}
+
+	conn, e := cf.connect()
+	if e != nil {
+		return e
+	}
+	defer func() { _ = conn.Close() }()
+
+	gn, e := generator(*spec, *plan, conn)
+	if e != nil {
+		return e
+	}
+
+	if gn.Strats() == nil {
+		if e := gn.CalcRates(); e != nil {
+			return e
+		}
+	}
+
+	gn.AuditTable(*audit)
+
+	if e := gn.MakeTable(*timeOut); e != nil {
+		return e
+	}
+
+	if _, e := fmt.Fprintln(w, gn); e != nil {
+		return e
+	}
+
+	return savePlan(gn, *save)
+}
+
+func report(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	cf := newConnFlags(fs)
+	plan := fs.String("plan", "", "plan saved by sample (required)")
+	html := fs.String("html", "", "file to write an HTML report to; otherwise the comparison is printed")
+
+	if e := fs.Parse(args); e != nil {
+		return e
+	}
+
+	if *plan == "" {
+		return fmt.Errorf("report: -plan is required")
+	}
+
+	conn, e := cf.connect()
+	if e != nil {
+		return e
+	}
+	defer func() { _ = conn.Close() }()
+
+	gn, e := generator("", *plan, conn)
+	if e != nil {
+		return e
+	}
+
+	if gn.SampleStrats() == nil {
+		return fmt.Errorf("report: plan has no sample, run sample with -save first")
+	}
+
+	if *html != "" {
+		return gn.Report(*html)
+	}
+
+	_, e = fmt.Fprintln(w, gn)
+
+	return e
+}
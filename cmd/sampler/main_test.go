@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NotNil(t, run(nil, buf))
+	assert.NotNil(t, run([]string{"explode"}, buf))
+	assert.NotNil(t, run([]string{"strat", "-fields", "state"}, buf))
+	assert.NotNil(t, run([]string{"rates"}, buf))
+
+	_, e := stratWriter("csv")
+	assert.Nil(t, e)
+	_, e = stratWriter("xls")
+	assert.NotNil(t, e)
+}
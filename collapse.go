@@ -0,0 +1,392 @@
+package sampler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// collapseSparse merges strata with fewer than minCount rows according to the collapse policy.
+// With CollapseNeighbor, a sparse stratum is first merged into its nearest neighbor on the last ordered field, if it
+// has one.  The fields of the remaining sparse strata are set to Other one at a time, from the last field to the first,
+// until the stratum they merge into has minCount rows.  So (P, Feb) and (P, Mar) merge into (P, Other) if that has
+// enough rows, and into (Other, Other) if not.  If keepLast is set, the last field, such as a class label, is never
+// collapsed, so a stratum may be left with fewer than minCount rows.  The values of a field with Other are
+// replaced by their labels, so the field is a string, as it is when exported.
+// The strata as found in the data are retained in rawKeys/rawCount and group maps each of these to its
+// collapsed stratum.
+func (strt *Strat) collapseSparse() {
+	ord := -1
+	if strt.collapse == CollapseNeighbor {
+		ord = strt.orderedField()
+	}
+
+	// cur is the key of the stratum each raw stratum is merged into
+	cur := make([][]any, len(strt.rawKeys))
+	settled := make([]bool, len(strt.rawKeys))
+	dense := make([]int, len(strt.rawKeys))
+	for ind, c := range strt.rawCount {
+		cur[ind] = append([]any{}, strt.rawKeys[ind]...)
+		settled[ind] = c >= uint64(strt.minCount)
+		dense[ind] = -1
+		if settled[ind] {
+			dense[ind] = ind
+		}
+	}
+
+	if ord >= 0 {
+		for ind := range strt.rawKeys {
+			if settled[ind] {
+				continue
+			}
+
+			if nb := strt.neighbor(ind, ord, dense); nb >= 0 {
+				cur[ind], settled[ind] = cur[nb], true
+			}
+		}
+	}
+
+	for col := strt.lastCollapsible(); col >= 0; col-- {
+		sizes := make(map[string]uint64)
+		for ind, key := range cur {
+			sizes[keyString(key)] += strt.rawCount[ind]
+		}
+
+		for ind := range cur {
+			if settled[ind] {
+				continue
+			}
+
+			if sizes[keyString(cur[ind])] >= uint64(strt.minCount) {
+				settled[ind] = true
+				continue
+			}
+
+			cur[ind][col] = Other
+		}
+	}
+
+	// strata with sufficient rows come first, then the merged strata in the order they're found
+	keys := make([][]any, 0)
+	counts := make([]uint64, 0)
+	group := make([]int, len(strt.rawKeys))
+	index := make(map[string]int)
+	for _, pass := range []bool{true, false} {
+		for ind, key := range cur {
+			if (dense[ind] >= 0) != pass {
+				continue
+			}
+
+			ks := keyString(key)
+			grp, ok := index[ks]
+			if !ok {
+				grp = len(keys)
+				index[ks] = grp
+				keys = append(keys, key)
+				counts = append(counts, 0)
+			}
+
+			group[ind] = grp
+			counts[grp] += strt.rawCount[ind]
+		}
+	}
+
+	// the merged strata are last unless we're sorting by counts
+	order := make([]int, len(keys))
+	for ind := 0; ind < len(order); ind++ {
+		order[ind] = ind
+	}
+
+	if strt.sortByCounts {
+		sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+	}
+
+	newInd := make([]int, len(keys))
+	strt.keys, strt.count = make([][]any, len(keys)), make([]uint64, len(keys))
+	for ind, o := range order {
+		strt.keys[ind], strt.count[ind] = keys[o], counts[o]
+		newInd[o] = ind
+	}
+
+	for ind := 0; ind < len(group); ind++ {
+		group[ind] = newInd[group[ind]]
+	}
+
+	strt.group = group
+	strt.measures = groupSum(strt.rawMeasures, group, len(strt.keys))
+	strt.labelOther(strt)
+}
+
+// dropSparse moves the strata with fewer than minCount rows to dropKeys/dropCount if the collapse policy is
+// CollapseNone.  They're kept so that strata that gain rows when the data is refreshed are counted in full.
+func (strt *Strat) dropSparse() {
+	if strt.minCount <= 0 || strt.collapse != CollapseNone {
+		return
+	}
+
+	keep := make([]int, 0, len(strt.keys))
+	for ind, c := range strt.count {
+		if c >= uint64(strt.minCount) {
+			keep = append(keep, ind)
+			continue
+		}
+
+		strt.dropKeys, strt.dropCount = append(strt.dropKeys, strt.keys[ind]), append(strt.dropCount, c)
+		if strt.measures != nil {
+			strt.dropMeasures = append(strt.dropMeasures, strt.measures[ind])
+		}
+	}
+
+	keys, count, n := make([][]any, len(keep)), make([]uint64, len(keep)), uint64(0)
+	var measures []float64
+	for ind, k := range keep {
+		keys[ind], count[ind] = strt.keys[k], strt.count[k]
+		n += count[ind]
+		if strt.measures != nil {
+			measures = append(measures, strt.measures[k])
+		}
+	}
+
+	for _, sm := range strt.summaries {
+		vals := make([]float64, len(keep))
+		for ind, k := range keep {
+			vals[ind] = sm.Values[k]
+		}
+		sm.Values = vals
+	}
+
+	strt.keys, strt.count, strt.measures, strt.n = keys, count, measures, n
+}
+
+// collapseLike maps the strata of strt into the collapsed strata of ref.  This is used to express the strats of a
+// sample in terms of the strata the sampling rates were calculated for.
+func (strt *Strat) collapseLike(ref *Strat) {
+	if !ref.collapsed() {
+		return
+	}
+
+	lookup := make(map[string]int)
+	for ind, key := range ref.rawKeys {
+		lookup[keyString(key)] = ref.group[ind]
+	}
+
+	refKeys := append([][]any{}, ref.keys...)
+	counts := make([]uint64, len(refKeys))
+	group := make([]int, len(strt.keys))
+	for ind, key := range strt.keys {
+		grp, ok := lookup[keyString(key)]
+		if !ok {
+			// not in the population strats, so leave it in a stratum of its own
+			grp = len(counts)
+			refKeys = append(refKeys, key)
+			counts = append(counts, 0)
+		}
+		group[ind] = grp
+		counts[grp] += strt.count[ind]
+	}
+
+	// keep ref's ordering, dropping strata with no rows
+	keys := make([][]any, 0)
+	cnts := make([]uint64, 0)
+	newInd := make([]int, len(counts))
+	for ind, c := range counts {
+		newInd[ind] = -1
+		if c == 0 {
+			continue
+		}
+		newInd[ind] = len(keys)
+		keys = append(keys, refKeys[ind])
+		cnts = append(cnts, c)
+	}
+
+	for ind := 0; ind < len(group); ind++ {
+		group[ind] = newInd[group[ind]]
+	}
+
+	strt.rawKeys, strt.rawCount, strt.rawMeasures, strt.group = strt.keys, strt.count, strt.measures, group
+	strt.keys, strt.count = keys, cnts
+	strt.measures = groupSum(strt.rawMeasures, group, len(keys))
+	strt.labelOther(ref)
+}
+
+// labelOther replaces the values of the fields that have strata collapsed into Other in ref with their labels, so
+// that each field of keys holds values of a single type: its own or, if it has Other, string.  Labelling like ref
+// keeps the keys of strt and ref comparable.  rawKeys keep their types.
+func (strt *Strat) labelOther(ref *Strat) {
+	for col := range strt.fields {
+		other := false
+		for _, key := range ref.keys {
+			if v, ok := key[col].(string); ok && v == Other {
+				other = true
+				break
+			}
+		}
+
+		if !other {
+			continue
+		}
+
+		for ind, key := range strt.keys {
+			if _, ok := key[col].(string); ok {
+				continue
+			}
+
+			// keys may share their values with rawKeys, so replace the key rather than change it
+			lbl := append([]any{}, key...)
+			lbl[col] = strt.format(col, key[col])
+			strt.keys[ind] = lbl
+		}
+	}
+}
+
+// groupSum sums x within the groups given by group.  It returns nil if x is nil.  The sums are the measures of the
+// groups if the measure is additive; Make and makeSampleStrats replace them with the measures calculated from the data.
+func groupSum(x []float64, group []int, nGroup int) []float64 {
+	if x == nil {
+		return nil
+	}
+
+	sums := make([]float64, nGroup)
+	for ind, grp := range group {
+		sums[grp] += x[ind]
+	}
+
+	return sums
+}
+
+// collapsed returns true if some strata have been merged.
+func (strt *Strat) collapsed() bool {
+	return len(strt.rawKeys) != len(strt.keys)
+}
+
+// lastCollapsible returns the index of the last field that may be collapsed.
+func (strt *Strat) lastCollapsible() int {
+	if strt.keepLast {
+		return len(strt.fields) - 2
+	}
+
+	return len(strt.fields) - 1
+}
+
+// orderedField returns the index of the last field that may be collapsed whose values can be ordered (dates, integers).
+// It returns -1 if there is no such field.
+func (strt *Strat) orderedField() int {
+	if len(strt.rawKeys) == 0 {
+		return -1
+	}
+
+	for col := strt.lastCollapsible(); col >= 0; col-- {
+		if _, ok := toFloat(strt.rawKeys[0][col]); ok {
+			return col
+		}
+	}
+
+	return -1
+}
+
+// neighbor finds the stratum among those that are not collapsed that matches rawKeys[ind] on every field but ord and
+// is nearest to it on ord.  It returns -1 if there is none.
+func (strt *Strat) neighbor(ind, ord int, group []int) int {
+	target, _ := toFloat(strt.rawKeys[ind][ord])
+	best, bestDist := -1, 0.0
+
+	for cand, key := range strt.rawKeys {
+		if group[cand] < 0 || strt.rawCount[cand] < uint64(strt.minCount) {
+			continue
+		}
+
+		match := true
+		for col := 0; col < len(key); col++ {
+			if col != ord && !sameValue(key[col], strt.rawKeys[ind][col]) {
+				match = false
+				break
+			}
+		}
+
+		if !match {
+			continue
+		}
+
+		val, _ := toFloat(key[ord])
+		dist := val - target
+		if dist < 0 {
+			dist = -dist
+		}
+
+		if best < 0 || dist < bestDist {
+			best, bestDist = cand, dist
+		}
+	}
+
+	return best
+}
+
+// toFloat converts ordered strat values to float64.  Dates are converted to Unix seconds.
+func toFloat(x any) (float64, bool) {
+	switch val := x.(type) {
+	case time.Time:
+		return float64(val.Unix()), true
+	case int8:
+		return float64(val), true
+	case int16:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint8:
+		return float64(val), true
+	case uint16:
+		return float64(val), true
+	case uint32:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	default:
+		return 0, false
+	}
+}
+
+func sameValue(a, b any) bool {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Equal(tb)
+		}
+		return false
+	}
+
+	return a == b
+}
+
+// keyString returns a string that uniquely identifies a stratum.
+func keyString(key []any) string {
+	vals := make([]string, len(key))
+	for ind, k := range key {
+		switch val := k.(type) {
+		case time.Time:
+			vals[ind] = val.UTC().Format(time.RFC3339)
+		default:
+			vals[ind] = fmt.Sprintf("%v", val)
+		}
+	}
+
+	return strings.Join(vals, "\x00")
+}
+
+func (c CollapsePolicy) String() string {
+	switch c {
+	case CollapseNone:
+		return "none"
+	case CollapseOther:
+		return "other"
+	case CollapseNeighbor:
+		return "neighbor"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,60 @@
+package sampler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrat_collapseSparse(t *testing.T) {
+	dt := func(m int) time.Time { return time.Date(2020, time.Month(m), 1, 0, 0, 0, 0, time.UTC) }
+	strt := &Strat{
+		fields:   []string{"purpose", "vintageDt"},
+		minCount: 10,
+		rawKeys:  [][]any{{"P", dt(1)}, {"P", dt(2)}, {"P", dt(4)}, {"C", dt(1)}, {"R", dt(1)}},
+		rawCount: []uint64{100, 5, 50, 20, 3},
+	}
+
+	strt.collapse = CollapseOther
+	strt.collapseSparse()
+	assert.Equal(t, []uint64{100, 50, 20, 8}, strt.count)
+	assert.Equal(t, []any{Other, Other}, strt.keys[3])
+	assert.Equal(t, []int{0, 3, 1, 2, 3}, strt.group)
+
+	// (P, Feb) is nearer Jan than Apr; (R, Jan) has no neighbor
+	strt.collapse = CollapseNeighbor
+	strt.collapseSparse()
+	assert.Equal(t, []uint64{105, 50, 20, 3}, strt.count)
+	assert.Equal(t, []int{0, 0, 1, 2, 3}, strt.group)
+
+	smp := &Strat{fields: strt.fields, keys: [][]any{{"P", dt(2)}, {"P", dt(1)}, {"R", dt(1)}}, count: []uint64{1, 2, 3}}
+	smp.collapseLike(strt)
+	assert.Equal(t, []uint64{3, 3}, smp.count)
+	assert.Equal(t, []any{Other, Other}, smp.keys[1])
+}
+
+func TestStrat_collapseSparsePerField(t *testing.T) {
+	dt := func(m int) time.Time { return time.Date(2020, time.Month(m), 1, 0, 0, 0, 0, time.UTC) }
+	strt := &Strat{
+		fields:   []string{"purpose", "vintageDt"},
+		minCount: 10,
+		collapse: CollapseOther,
+		rawKeys:  [][]any{{"P", dt(1)}, {"P", dt(2)}, {"P", dt(3)}, {"C", dt(1)}, {"R", dt(1)}, {"R", dt(2)}},
+		rawCount: []uint64{100, 5, 6, 20, 3, 4},
+	}
+
+	// (P, Feb) and (P, Mar) have enough rows together, (R, Jan) and (R, Feb) don't
+	strt.collapseSparse()
+	// vintageDt has Other, so its values are labels
+	assert.Equal(t, [][]any{{"P", "2020-01-01"}, {"C", "2020-01-01"}, {"P", Other}, {Other, Other}}, strt.keys)
+	assert.Equal(t, []uint64{100, 20, 11, 7}, strt.count)
+	assert.Equal(t, []int{0, 2, 2, 1, 3, 3}, strt.group)
+	assert.Equal(t, []any{"P", dt(1)}, strt.rawKeys[0])
+
+	// the sample is labelled like the population even if it has no Other stratum
+	smp := &Strat{fields: strt.fields, keys: [][]any{{"C", dt(1)}, {"P", dt(1)}}, count: []uint64{4, 9}}
+	smp.collapseLike(strt)
+	assert.Equal(t, [][]any{{"P", "2020-01-01"}, {"C", "2020-01-01"}}, smp.keys)
+	assert.Equal(t, []any{"C", dt(1)}, smp.rawKeys[0])
+}
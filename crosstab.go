@@ -0,0 +1,235 @@
+package sampler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+	"github.com/dustin/go-humanize"
+	"github.com/invertedv/utilities"
+)
+
+// Percent specifies which percentages a Crosstab displays alongside the counts.
+type Percent int
+
+const (
+	PctNone  Percent = 0 + iota // PctNone shows no percentages
+	PctRow                      // PctRow shows the percentage of the row total
+	PctCol                      // PctCol shows the percentage of the column total
+	PctTotal                    // PctTotal shows the percentage of the grand total
+)
+
+// Crosstab is the two-way table of counts of a strat. If the strat has other fields, they are summed over.
+type Crosstab struct {
+	RowField  string     // RowField is the strat field that forms the rows
+	ColField  string     // ColField is the strat field that forms the columns
+	Rows      []string   // Rows are the formatted values of RowField
+	Cols      []string   // Cols are the formatted values of ColField
+	Counts    [][]uint64 // Counts[i][j] is the count for Rows[i], Cols[j]
+	RowTotals []uint64   // RowTotals are the totals of each row
+	ColTotals []uint64   // ColTotals are the totals of each column
+	Total     uint64     // Total is the grand total
+	Percent   Percent    // Percent specifies the percentages shown alongside the counts
+}
+
+// Crosstab returns the two-way table of rowField by colField.
+func (strt *Strat) Crosstab(rowField, colField string) (*Crosstab, error) {
+	rCol, cCol := -1, -1
+	for ind, fld := range strt.fields {
+		switch fld {
+		case rowField:
+			rCol = ind
+		case colField:
+			cCol = ind
+		}
+	}
+
+	if rCol < 0 || cCol < 0 || rCol == cCol {
+		return nil, fmt.Errorf("(*Strat) Crosstab: %s and %s must be different strat fields", rowField, colField)
+	}
+
+	ct := &Crosstab{RowField: rowField, ColField: colField}
+	rowInd, colInd := make(map[string]int), make(map[string]int)
+	type cell struct{ row, col int }
+	cells := make(map[cell]uint64)
+
+	// rows and columns are in order of appearance in the strat
+	for ind, key := range strt.keys {
+		r, c := strt.format(rCol, key[rCol]), strt.format(cCol, key[cCol])
+		if _, ok := rowInd[r]; !ok {
+			rowInd[r] = len(ct.Rows)
+			ct.Rows = append(ct.Rows, r)
+		}
+
+		if _, ok := colInd[c]; !ok {
+			colInd[c] = len(ct.Cols)
+			ct.Cols = append(ct.Cols, c)
+		}
+
+		cells[cell{rowInd[r], colInd[c]}] += strt.count[ind]
+	}
+
+	ct.Counts = make([][]uint64, len(ct.Rows))
+	ct.RowTotals, ct.ColTotals = make([]uint64, len(ct.Rows)), make([]uint64, len(ct.Cols))
+	for r := 0; r < len(ct.Rows); r++ {
+		ct.Counts[r] = make([]uint64, len(ct.Cols))
+		for c := 0; c < len(ct.Cols); c++ {
+			cnt := cells[cell{r, c}]
+			ct.Counts[r][c] = cnt
+			ct.RowTotals[r] += cnt
+			ct.ColTotals[c] += cnt
+			ct.Total += cnt
+		}
+	}
+
+	return ct, nil
+}
+
+// Pct returns the percentages of type pct for each cell. Percentages are on a 0-100 scale.
+// For PctNone, it returns nil.
+func (ct *Crosstab) Pct(pct Percent) [][]float64 {
+	if pct == PctNone {
+		return nil
+	}
+
+	pcts := make([][]float64, len(ct.Rows))
+	for r := 0; r < len(ct.Rows); r++ {
+		pcts[r] = make([]float64, len(ct.Cols))
+		for c := 0; c < len(ct.Cols); c++ {
+			var base uint64
+			switch pct {
+			case PctRow:
+				base = ct.RowTotals[r]
+			case PctCol:
+				base = ct.ColTotals[c]
+			case PctTotal:
+				base = ct.Total
+			}
+
+			if base > 0 {
+				pcts[r][c] = 100.0 * float64(ct.Counts[r][c]) / float64(base)
+			}
+		}
+	}
+
+	return pcts
+}
+
+// table returns the cells of the crosstab, including headers and totals, as strings.
+// If commas is true, counts are formatted with commas.
+func (ct *Crosstab) table(commas bool) [][]string {
+	pcts := ct.Pct(ct.Percent)
+	cnt := func(c uint64) string {
+		if commas {
+			return humanize.Comma(int64(c))
+		}
+		return fmt.Sprintf("%d", c)
+	}
+
+	tbl := make([][]string, 0)
+	tbl = append(tbl, append(append([]string{fmt.Sprintf("%s \\ %s", ct.RowField, ct.ColField)}, ct.Cols...), "Total"))
+
+	for r, row := range ct.Rows {
+		line := []string{row}
+		for c := 0; c < len(ct.Cols); c++ {
+			val := cnt(ct.Counts[r][c])
+			if pcts != nil {
+				val = fmt.Sprintf("%s (%0.1f%%)", val, pcts[r][c])
+			}
+			line = append(line, val)
+		}
+		tbl = append(tbl, append(line, cnt(ct.RowTotals[r])))
+	}
+
+	line := []string{"Total"}
+	for _, tot := range ct.ColTotals {
+		line = append(line, cnt(tot))
+	}
+	tbl = append(tbl, append(line, cnt(ct.Total)))
+
+	return tbl
+}
+
+func (ct *Crosstab) String() string {
+	const spaces = 4
+
+	tbl := ct.table(true)
+	widths := make([]int, len(tbl[0]))
+	for _, line := range tbl {
+		for col, val := range line {
+			widths[col] = Max(widths[col], len(val))
+		}
+	}
+
+	str := ""
+	for _, line := range tbl {
+		for col, val := range line {
+			// first column is left-justified, counts are right-justified
+			switch col {
+			case 0:
+				str = fmt.Sprintf("%s%s", str, padder(val, widths[col]+spaces, true))
+			default:
+				str = fmt.Sprintf("%s%s", str, padder(padder(val, widths[col], false), widths[col]+spaces, true))
+			}
+		}
+		str += "\n"
+	}
+
+	return str
+}
+
+// WriteCSV writes the crosstab to w as CSV.
+func (ct *Crosstab) WriteCSV(w io.Writer) error {
+	wtr := csv.NewWriter(w)
+	if e := wtr.WriteAll(ct.table(false)); e != nil {
+		return e
+	}
+
+	return wtr.Error()
+}
+
+// WriteMarkdown writes the crosstab to w as a markdown table.
+func (ct *Crosstab) WriteMarkdown(w io.Writer) error {
+	tbl := ct.table(true)
+	align := []string{":---"}
+	for col := 1; col < len(tbl[0]); col++ {
+		align = append(align, "---:")
+	}
+
+	lines := make([]string, 0)
+	for ind, line := range tbl {
+		lines = append(lines, fmt.Sprintf("| %s |", strings.Join(line, " | ")))
+		if ind == 0 {
+			lines = append(lines, fmt.Sprintf("| %s |", strings.Join(align, " | ")))
+		}
+	}
+
+	_, e := fmt.Fprintln(w, strings.Join(lines, "\n"))
+
+	return e
+}
+
+// Plot plots the crosstab as a heatmap. The cells are colored by the count or, if Percent is not PctNone, the
+// percentage.
+func (ct *Crosstab) Plot(outDir, outFile string, imageTypes []utilities.PlotlyImage, show bool, opts ...PlotOpt) error {
+	z := make([][]float64, len(ct.Rows))
+	for r := 0; r < len(ct.Rows); r++ {
+		z[r] = make([]float64, len(ct.Cols))
+		for c := 0; c < len(ct.Cols); c++ {
+			z[r][c] = float64(ct.Counts[r][c])
+		}
+	}
+
+	title := "Observation Count"
+	if pcts := ct.Pct(ct.Percent); pcts != nil {
+		z, title = pcts, "Observation Percent"
+	}
+
+	tr := &grob.Heatmap{X: ct.Cols, Y: ct.Rows, Z: z, Type: grob.TraceTypeHeatmap}
+	fig := &grob.Fig{Data: grob.Traces{tr}}
+
+	return newPlotOpts(opts...).plot(fig, nil, fmt.Sprintf("%s By %s and %s", title, ct.RowField, ct.ColField),
+		ct.ColField, ct.RowField, false, outDir, outFile, imageTypes, show)
+}
@@ -0,0 +1,35 @@
+package sampler
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrat_Crosstab(t *testing.T) {
+	strt := &Strat{
+		fields: []string{"state", "purpose"},
+		keys:   [][]any{{"CA", "P"}, {"CA", "C"}, {"NY", "P"}},
+		count:  []uint64{3000, 1000, 4000},
+	}
+
+	ct, e := strt.Crosstab("state", "purpose")
+	assert.Nil(t, e)
+	assert.Equal(t, [][]uint64{{3000, 1000}, {4000, 0}}, ct.Counts)
+	assert.Equal(t, []uint64{7000, 1000}, ct.ColTotals)
+	assert.Equal(t, uint64(8000), ct.Total)
+	assert.Equal(t, 75.0, ct.Pct(PctRow)[0][0])
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, ct.WriteCSV(buf))
+	assert.Equal(t, "state \\ purpose,P,C,Total\nCA,3000,1000,4000\nNY,4000,0,4000\nTotal,7000,1000,8000\n", buf.String())
+
+	ct.Percent = PctCol
+	buf.Reset()
+	assert.Nil(t, ct.WriteMarkdown(buf))
+	assert.Contains(t, buf.String(), "| CA | 3,000 (42.9%) | 1,000 (100.0%) | 4,000 |")
+
+	_, e = strt.Crosstab("state", "state")
+	assert.NotNil(t, e)
+}
@@ -0,0 +1,84 @@
+package sampler
+
+import (
+	"fmt"
+
+	"github.com/invertedv/chutils"
+)
+
+// MakeDisjoint makes n samples from Query in one pass, each balanced according to the rates found by CalcRates.
+// No row is in more than one sample.  The samples are the tables <sampleTable>_1, ..., <sampleTable>_n.
+//
+// Each row is assigned to a sample by its draw u: it goes to sample floor(u/rate)+1, if that's no larger than n.
+// A stratum with n*rate > 1 doesn't have the rows to fill all n samples, so the later samples are short of rows of
+// that stratum.  The labels of these strata are returned in short.  The samples have the split and weight columns
+// of sampleTable; tiered samples can't be made disjoint.
+// timeOut is the query time out in minutes.
+func (gn *Generator) MakeDisjoint(n int, timeOut int64) (tables, short []string, err error) {
+	if gn.strats == nil {
+		return nil, nil, fmt.Errorf("(*Generator) MakeDisjoint: must run CalcRates first")
+	}
+
+	if gn.ppsSize != "" {
+		return nil, nil, fmt.Errorf("(*Generator) MakeDisjoint: cannot make disjoint PPS samples")
+	}
+
+	if gn.tiers != nil {
+		return nil, nil, fmt.Errorf("(*Generator) MakeDisjoint: cannot make disjoint tiered samples")
+	}
+
+	if n < 1 {
+		return nil, nil, fmt.Errorf("(*Generator) MakeDisjoint: n must be at least 1, got %d", n)
+	}
+
+	if e := gn.Save(); e != nil {
+		return nil, nil, e
+	}
+
+	chutils.WithTimeOut(timeOut)(gn.conn)
+
+	stage := gn.sampleTable + "_stage"
+	if e := makeTable(gn.disjointQuery(n), stage, gn.conn); e != nil {
+		return nil, nil, e
+	}
+
+	for ind := 0; ind < n; ind++ {
+		table := fmt.Sprintf("%s_%d", gn.sampleTable, ind+1)
+		qry := fmt.Sprintf("SELECT * EXCEPT (sampleIndex) FROM %s WHERE sampleIndex = %d", stage, ind)
+		if e := makeTable(qry, table, gn.conn); e != nil {
+			return nil, nil, e
+		}
+
+		tables = append(tables, table)
+	}
+
+	if e := gn.conn.Execute(fmt.Sprintf("DROP TABLE %s", stage)); e != nil {
+		return nil, nil, e
+	}
+
+	return tables, gn.shortStrata(n), nil
+}
+
+// disjointQuery returns the query that selects the rows of n disjoint samples from Query.  The sampleIndex column
+// gives the (0-based) sample the row is in.  The other columns are those of sampleTable.
+func (gn *Generator) disjointQuery(n int) string {
+	u := gn.draw("a", 0)
+	sel := fmt.Sprintf("%s,\n  toUInt32(floor(%s / b.sampleRate)) AS sampleIndex", gn.sampleCols(), u)
+
+	qry := fmt.Sprintf("SELECT\n  %s\nFROM\n  (%s) AS a\nJOIN\n  %s AS b\n ON \n", sel, gn.available(gn.Query), gn.stratTable)
+	qry = fmt.Sprintf("%s %s", qry, gn.joins("a", "b"))
+
+	return fmt.Sprintf("%s WHERE b.sampleRate > 0 AND %s < %d * b.sampleRate\n", qry, u, n)
+}
+
+// shortStrata returns the labels of the strata that can't supply n disjoint samples at their sampling rate.
+func (gn *Generator) shortStrata(n int) []string {
+	short := make([]string, 0)
+	for ind, key := range gn.strats.keys {
+		if float64(n)*gn.sampleRate[ind] > 1 {
+			short = append(short, gn.strats.label(key))
+		}
+	}
+
+	return short
+}
@@ -0,0 +1,33 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_disjoint(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.strats.fields = []string{"state"}
+	gn.strats.keys = [][]any{{"TX"}, {"VT"}, {"WY"}}
+	gn.sampleRate = []float64{0.1, 0.4, 1.0}
+
+	qry := gn.disjointQuery(3)
+	assert.Contains(t, qry, "toUInt32(floor(rand32(rowNumberInAllBlocks()) / 4294967295.0 / b.sampleRate)) AS sampleIndex")
+	assert.Contains(t, qry, "a.state = b.state")
+	assert.Contains(t, qry, "< 3 * b.sampleRate")
+
+	assert.Equal(t, []string{"VT", "WY"}, gn.shortStrata(3))
+	assert.Equal(t, []string{"WY"}, gn.shortStrata(2))
+	assert.Empty(t, gn.shortStrata(1))
+
+	// class-balanced samples keep their weights
+	gn.classLabel = "default"
+	assert.Contains(t, gn.disjointQuery(3), "1.0 / b.sampleRate AS weight")
+
+	assert.Nil(t, gn.Tiers(100, 200))
+	gn.strats = NewStrat(gn.Query, nil, false)
+	_, _, e := gn.MakeDisjoint(2, 0)
+	assert.NotNil(t, e)
+}
@@ -0,0 +1,130 @@
+package sampler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Exclude names a source of keys whose rows must never be sampled, such as a locked holdout set or the IDs used
+// to build a previous model.  source is either a table or a query.  keys are the columns that identify a row, which
+// must be in both Query and source.
+//
+// The exclusion is applied before the strats are made, so the sampling rates reflect the rows that are available.
+// The rows excluded from each stratum are reported by String and Report.
+// Calling Exclude with an empty source turns off the exclusion.
+func (gn *Generator) Exclude(source string, keys ...string) error {
+	if strings.TrimSpace(source) == "" {
+		gn.excludeSource, gn.excludeKeys = "", nil
+		gn.reset()
+
+		return nil
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("(*Generator) Exclude: must specify key columns")
+	}
+
+	gn.excludeSource, gn.excludeKeys = source, keys
+	gn.reset()
+
+	return nil
+}
+
+// available returns query with the rows in the exclusion source removed.
+func (gn *Generator) available(query string) string {
+	if gn.excludeSource == "" {
+		return query
+	}
+
+	return fmt.Sprintf("SELECT * FROM (%s) WHERE %s", query, gn.exclusion("NOT IN"))
+}
+
+// excludedQuery returns the query that selects the rows of Query in the exclusion source.
+func (gn *Generator) excludedQuery() string {
+	return fmt.Sprintf("SELECT * FROM (%s) WHERE %s", gn.Query, gn.exclusion("IN"))
+}
+
+// exclusion returns the condition that the keys are (op is IN) or are not (op is NOT IN) in the exclusion source.
+func (gn *Generator) exclusion(op string) string {
+	keys := strings.Join(gn.excludeKeys, ", ")
+	src := gn.excludeSource
+	if first := strings.ToUpper(strings.Fields(src)[0]); first == "SELECT" || first == "WITH" {
+		src = fmt.Sprintf("(%s)", src)
+	}
+
+	return fmt.Sprintf("(%s) %s (SELECT %s FROM %s)", keys, op, keys, src)
+}
+
+// makeExcluded makes the strats of the excluded rows, expressed in the strata of gn.strats.
+func (gn *Generator) makeExcluded() error {
+	gn.excluded = nil
+	if gn.excludeSource == "" {
+		return nil
+	}
+
+	excl := NewStrat(gn.excludedQuery(), gn.conn, false)
+	excl.specs = copySpecs(gn.strats.specs)
+	if e := excl.Make(gn.strats.fields...); e != nil {
+		return e
+	}
+	excl.collapseLike(gn.strats)
+	gn.excluded = excl
+
+	return nil
+}
+
+// exclusionTable returns the table of the rows excluded from each stratum.  Strata all of whose rows are excluded
+// come last.
+func (gn *Generator) exclusionTable() *reportTable {
+	tbl := &reportTable{
+		Title: "Excluded Rows By Stratum",
+		Heads: []string{"Stratum", "Available", "Excluded", "Excluded %"},
+	}
+
+	excl := make(map[string]uint64)
+	for ind, key := range gn.excluded.keys {
+		excl[keyString(key)] = gn.excluded.count[ind]
+	}
+
+	row := func(key []any, avail, ex uint64) []string {
+		return []string{
+			gn.strats.label(key),
+			humanize.Comma(int64(avail)),
+			humanize.Comma(int64(ex)),
+			fmt.Sprintf("%0.2f%%", 100.0*float64(ex)/float64(avail+ex)),
+		}
+	}
+
+	for ind, key := range gn.strats.keys {
+		ks := keyString(key)
+		tbl.Rows = append(tbl.Rows, row(key, gn.strats.count[ind], excl[ks]))
+		delete(excl, ks)
+	}
+
+	for ind, key := range gn.excluded.keys {
+		if _, ok := excl[keyString(key)]; ok {
+			tbl.Rows = append(tbl.Rows, row(key, 0, gn.excluded.count[ind]))
+		}
+	}
+
+	return tbl
+}
+
+// exclusionString returns the rows excluded from each stratum as a string.
+func (gn *Generator) exclusionString() string {
+	tbl := gn.exclusionTable()
+
+	width := len(tbl.Heads[0])
+	for _, r := range tbl.Rows {
+		width = Max(width, len(r[0]))
+	}
+
+	str := fmt.Sprintf("%s%15s%15s%15s\n", padder(tbl.Heads[0], width+4, true), tbl.Heads[1], tbl.Heads[2], tbl.Heads[3])
+	for _, r := range tbl.Rows {
+		str = fmt.Sprintf("%s%s%15s%15s%15s\n", str, padder(r[0], width+4, true), r[1], r[2], r[3])
+	}
+
+	return str
+}
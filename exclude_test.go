@@ -0,0 +1,45 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_Exclude(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	assert.Equal(t, "SELECT * FROM src", gn.available(gn.Query))
+	assert.NotNil(t, gn.Exclude("holdout"))
+
+	assert.Nil(t, gn.Exclude("holdout", "loanId"))
+	assert.Equal(t, "SELECT * FROM (SELECT * FROM src) WHERE (loanId) NOT IN (SELECT loanId FROM holdout)",
+		gn.available(gn.Query))
+
+	assert.Nil(t, gn.Exclude("select id, dt FROM model.ids", "id", "dt"))
+	assert.Equal(t, "SELECT * FROM (SELECT * FROM src) WHERE (id, dt) IN (SELECT id, dt FROM (select id, dt FROM model.ids))",
+		gn.excludedQuery())
+
+	// changing the exclusion drops the rates
+	gn.sampleRate = []float64{0.5}
+	assert.Nil(t, gn.Exclude(""))
+	assert.Equal(t, "SELECT * FROM src", gn.available(gn.Query))
+	assert.Nil(t, gn.sampleRate)
+}
+
+func TestGenerator_exclusionTable(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.strats.fields = []string{"state"}
+	gn.strats.keys, gn.strats.count = [][]any{{"TX"}, {"VT"}}, []uint64{900, 100}
+	gn.excluded = NewStrat(gn.Query, nil, false)
+	gn.excluded.fields = []string{"state"}
+	gn.excluded.keys, gn.excluded.count = [][]any{{"TX"}, {"WY"}}, []uint64{100, 5}
+
+	tbl := gn.exclusionTable()
+	assert.Equal(t, [][]string{
+		{"TX", "900", "100", "10.00%"},
+		{"VT", "100", "0", "0.00%"},
+		{"WY", "0", "5", "100.00%"},
+	}, tbl.Rows)
+	assert.Contains(t, gn.exclusionString(), "WY")
+}
@@ -0,0 +1,127 @@
+package sampler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/invertedv/chutils"
+	s "github.com/invertedv/chutils/sql"
+)
+
+// Plan describes what MakeTable will do.  It is returned by Explain.
+type Plan struct {
+	StratDDL    string      // StratDDL outlines the CREATE statement for stratTable, for display
+	SampleDDL   string      // SampleDDL outlines the CREATE statement for sampleTable, for display
+	Query       string      // Query is the query that selects the sample
+	Strata      []string    // Strata are the labels of the strata
+	Counts      []uint64    // Counts are the number of rows in each stratum
+	Rates       []float64   // Rates are the sampling rates of each stratum
+	Expected    []float64   // Expected is the expected number of rows sampled from each stratum
+	ExpCaptured int         // ExpCaptured is the expected size of sampleTable
+	Trace       []Iteration // Trace has the iterations of the calculation of the sampling rates
+}
+
+// Explain returns the plan of MakeTable without creating any tables.  CalcRates must be run first.  The schema of
+// sampleTable is found by running Query with LIMIT 1.
+func (gn *Generator) Explain() (*Plan, error) {
+	if gn.strats == nil {
+		return nil, fmt.Errorf("(*Generator) Explain: must run CalcRates first")
+	}
+
+	if len(gn.strats.keys) == 0 {
+		return nil, fmt.Errorf("(*Generator) Explain: strats are empty")
+	}
+
+	td, e := gn.stratTableDef()
+	if e != nil {
+		return nil, e
+	}
+
+	plan := &Plan{
+		StratDDL:    ddl(td, gn.stratTable),
+		Query:       gn.sampleQuery(gn.Query),
+		Rates:       gn.sampleRate,
+		Counts:      gn.strats.count,
+		ExpCaptured: gn.expCaptured,
+		Trace:       gn.trace,
+	}
+
+	for ind, key := range gn.strats.keys {
+		plan.Strata = append(plan.Strata, gn.strats.label(key))
+		plan.Expected = append(plan.Expected, gn.sampleRate[ind]*float64(gn.strats.count[ind]))
+	}
+
+	// sampleTable has the columns of Query
+	rdr := s.NewReader(gn.Query, gn.conn)
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		return nil, e
+	}
+
+	std := rdr.TableSpec()
+	if gn.splits != nil || gn.tiers != nil || gn.classLabel != "" || gn.ppsSize != "" {
+		std = std.Copy(false)
+	}
+
+	if gn.splits != nil {
+		std.FieldDefs[len(std.FieldDefs)] = chutils.NewFieldDef("split", chutils.ChField{Base: chutils.ChString}, "", nil, nil, 0)
+	}
+
+	if gn.tiers != nil {
+		std.FieldDefs[len(std.FieldDefs)] = chutils.NewFieldDef("tier", chutils.ChField{Base: chutils.ChInt, Length: 64}, "", nil, nil, 0)
+	}
+
+	if gn.classLabel != "" {
+		std.FieldDefs[len(std.FieldDefs)] = chutils.NewFieldDef("weight", chutils.ChField{Base: chutils.ChFloat, Length: 64}, "", nil, nil, 0)
+	}
+
+	if gn.ppsSize != "" {
+		std.FieldDefs[len(std.FieldDefs)] = chutils.NewFieldDef("inclusionProb", chutils.ChField{Base: chutils.ChFloat, Length: 64}, "", nil, nil, 0)
+	}
+	plan.SampleDDL = ddl(std, gn.sampleTable)
+
+	return plan, nil
+}
+
+// ddl returns an outline of the CREATE statement for table with the columns, engine and key of td.  It's for display:
+// the statement td.Create runs is formatted by chutils and may differ from it.
+func ddl(td *chutils.TableDef, table string) string {
+	cols := make([]string, 0)
+	for ind := 0; ind < len(td.FieldDefs); ind++ {
+		fd := td.FieldDefs[ind]
+		if fd.Drop {
+			continue
+		}
+
+		col := fmt.Sprintf("  %s %v", fd.Name, fd.ChSpec)
+		if fd.Description != "" {
+			col = fmt.Sprintf("%s comment '%s'", col, fd.Description)
+		}
+		cols = append(cols, col)
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n) ENGINE=%v()\nORDER BY (%s)", table, strings.Join(cols, ",\n"), td.Engine, td.Key)
+}
+
+func (p *Plan) String() string {
+	str := fmt.Sprintf("Strats Table DDL:\n%s\n\nSample Table DDL:\n%s\n\nSampling Query:\n%s\n", p.StratDDL, p.SampleDDL, p.Query)
+
+	str = fmt.Sprintf("%s\nRate Calculation\n%-10s%15s%10s%15s%15s\n", str, "Iteration", "Target", "Free", "Lost Obs", "Captured")
+	for ind, it := range p.Trace {
+		str = fmt.Sprintf("%s%-10d%15s%10d%15s%15s\n", str, ind+1, humanize.Comma(int64(it.Target)), it.Free,
+			humanize.Comma(int64(it.LostObs)), humanize.Comma(int64(it.Captured)))
+	}
+
+	width := len("Stratum")
+	for _, st := range p.Strata {
+		width = Max(width, len(st))
+	}
+
+	str = fmt.Sprintf("%s\nExpected Sample\n%s%15s%15s%15s\n", str, padder("Stratum", width+4, true), "Count", "Rate", "Expected")
+	for ind, st := range p.Strata {
+		str = fmt.Sprintf("%s%s%15s%15.4f%15s\n", str, padder(st, width+4, true), humanize.Comma(int64(p.Counts[ind])),
+			p.Rates[ind], humanize.Comma(int64(p.Expected[ind])))
+	}
+
+	return fmt.Sprintf("%s\nExpected # Obs: %s\n", str, humanize.Comma(int64(p.ExpCaptured)))
+}
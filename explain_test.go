@@ -0,0 +1,29 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDDL(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM bk.loans", "tmp.sample", "tmp.strat", 2000, false, nil)
+	gn.strats = &Strat{
+		fields:   []string{"state", "fico"},
+		keys:     [][]any{{"CA", int32(700)}, {"NY", int32(650)}},
+		count:    []uint64{3000, 1000},
+		rawKeys:  [][]any{{"CA", int32(700)}, {"NY", int32(650)}},
+		rawCount: []uint64{3000, 1000},
+	}
+	gn.sampleRate = []float64{0.5, 0.5}
+
+	td, e := gn.stratTableDef()
+	assert.Nil(t, e)
+	assert.Equal(t, "CREATE TABLE tmp.strat (\n  state String,\n  fico Int32,\n  count Int64,\n  sampleRate Float64\n) "+
+		"ENGINE=MergeTree()\nORDER BY (state)", ddl(td, gn.stratTable))
+
+	_, _, trace := allocate([]float64{100, 2000, 4000}, 900, 0.5)
+	assert.Equal(t, 900, trace[0].Target)
+	assert.Equal(t, 2, trace[0].Free)
+	assert.Equal(t, 200, trace[0].LostObs)
+}
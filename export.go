@@ -0,0 +1,336 @@
+package sampler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/invertedv/chutils"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// column is a column of the strat table as exported by the Write methods.
+type column struct {
+	name  string
+	ch    chutils.ChField
+	field int   // index of the strat field, -1 for calculated columns
+	vals  []any // values of the column, one per stratum
+}
+
+// columns returns every column of the strat table: the strat fields, count, pct, cumPct, the measure and
+// the summaries.  pct is the percentage of rows in the stratum and cumPct is its cumulative value.
+func (strt *Strat) columns() []*column {
+	cols := make([]*column, 0)
+	for col, fld := range strt.fields {
+		c := &column{name: fld, ch: strt.exportType(col), field: col}
+		for _, key := range strt.keys {
+			c.vals = append(c.vals, key[col])
+		}
+		cols = append(cols, c)
+	}
+
+	float := chutils.ChField{Base: chutils.ChFloat, Length: 64}
+	cnt := &column{name: "count", ch: chutils.ChField{Base: chutils.ChInt, Length: 64}, field: -1}
+	pct, cumPct := &column{name: "pct", ch: float, field: -1}, &column{name: "cumPct", ch: float, field: -1}
+	cum := 0.0
+	for _, c := range strt.count {
+		p := 0.0
+		if strt.n > 0 {
+			p = 100.0 * float64(c) / float64(strt.n)
+		}
+		cum += p
+		cnt.vals, pct.vals, cumPct.vals = append(cnt.vals, int64(c)), append(pct.vals, p), append(cumPct.vals, cum)
+	}
+	cols = append(cols, cnt, pct, cumPct)
+
+	if strt.measure != "" {
+		c := &column{name: "measure", ch: float, field: -1}
+		for _, m := range strt.measures {
+			c.vals = append(c.vals, m)
+		}
+		cols = append(cols, c)
+	}
+
+	for _, sm := range strt.summaries {
+		c := &column{name: sm.Name(), ch: float, field: -1}
+		for _, v := range sm.Values {
+			c.vals = append(c.vals, v)
+		}
+		cols = append(cols, c)
+	}
+
+	return cols
+}
+
+// exportType returns the type of the col-th strat field.  Fields that have strata collapsed into Other are strings, as
+// are fields whose values are written as labels: binned, bucketed to months, quarters or years, or recoded.
+func (strt *Strat) exportType(col int) chutils.ChField {
+	ch := chutils.ChField{Base: chutils.ChString}
+	if strt.spec(strt.fields[col]).labelled() {
+		return ch
+	}
+
+	if col < len(strt.types) {
+		ch = chutils.ChField{Base: strt.types[col].Base, Length: strt.types[col].Length}
+	}
+
+	if ch.Base == chutils.ChString || ch.Base == chutils.ChFixedString {
+		return chutils.ChField{Base: chutils.ChString}
+	}
+
+	for _, key := range strt.keys {
+		if _, ok := key[col].(string); ok {
+			return chutils.ChField{Base: chutils.ChString}
+		}
+	}
+
+	return ch
+}
+
+// text returns the row-th value of c as a string. If commas is true, numbers are formatted with commas.
+func (strt *Strat) text(c *column, row int, commas bool) string {
+	if c.field >= 0 {
+		return strt.format(c.field, c.vals[row])
+	}
+
+	switch val := c.vals[row].(type) {
+	case int64:
+		if commas {
+			return humanize.Comma(val)
+		}
+		return strconv.FormatInt(val, 10)
+	case float64:
+		if commas {
+			return fmt.Sprintf("%0.2f", val)
+		}
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// WriteCSV writes every row of the strat table to w as CSV.  The first row has the column names and the second
+// row has their ClickHouse types, as in ClickHouse's CSVWithNamesAndTypes format.
+func (strt *Strat) WriteCSV(w io.Writer) error {
+	cols := strt.columns()
+	names, types := make([]string, len(cols)), make([]string, len(cols))
+	for ind, c := range cols {
+		names[ind], types[ind] = c.name, c.ch.String()
+	}
+
+	wtr := csv.NewWriter(w)
+	if e := wtr.Write(names); e != nil {
+		return e
+	}
+
+	if e := wtr.Write(types); e != nil {
+		return e
+	}
+
+	for row := 0; row < len(strt.count); row++ {
+		line := make([]string, len(cols))
+		for ind, c := range cols {
+			line[ind] = strt.text(c, row, false)
+		}
+
+		if e := wtr.Write(line); e != nil {
+			return e
+		}
+	}
+
+	wtr.Flush()
+
+	return wtr.Error()
+}
+
+// WriteJSON writes every row of the strat table to w as JSON lines.  The first line is an object whose "meta" key
+// has the name and ClickHouse type of each column.  Each following line is an object with the values of a stratum.
+// Dates are written as strings.
+func (strt *Strat) WriteJSON(w io.Writer) error {
+	type meta struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+
+	cols := strt.columns()
+	metas := make([]meta, len(cols))
+	for ind, c := range cols {
+		metas[ind] = meta{Name: c.name, Type: c.ch.String()}
+	}
+
+	enc := json.NewEncoder(w)
+	if e := enc.Encode(map[string][]meta{"meta": metas}); e != nil {
+		return e
+	}
+
+	for row := 0; row < len(strt.count); row++ {
+		// build the object by hand so the keys are in column order
+		elems := make([]string, len(cols))
+		for ind, c := range cols {
+			val := c.vals[row]
+			if c.field >= 0 && (c.ch.Base == chutils.ChDate || c.ch.Base == chutils.ChString) {
+				val = strt.text(c, row, false)
+			}
+
+			k, e := json.Marshal(c.name)
+			if e != nil {
+				return e
+			}
+
+			v, e := json.Marshal(val)
+			if e != nil {
+				return e
+			}
+			elems[ind] = fmt.Sprintf("%s:%s", k, v)
+		}
+
+		if _, e := fmt.Fprintf(w, "{%s}\n", strings.Join(elems, ",")); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// WriteMarkdown writes every row of the strat table to w as a markdown table.  The headers have the ClickHouse
+// type of each column.
+func (strt *Strat) WriteMarkdown(w io.Writer) error {
+	cols := strt.columns()
+	heads, align := make([]string, len(cols)), make([]string, len(cols))
+	for ind, c := range cols {
+		heads[ind], align[ind] = fmt.Sprintf("%s (%s)", c.name, c.ch.String()), "---:"
+		if c.field >= 0 {
+			align[ind] = ":---"
+		}
+	}
+
+	lines := []string{fmt.Sprintf("| %s |", strings.Join(heads, " | ")), fmt.Sprintf("| %s |", strings.Join(align, " | "))}
+	for row := 0; row < len(strt.count); row++ {
+		vals := make([]string, len(cols))
+		for ind, c := range cols {
+			vals[ind] = strt.text(c, row, true)
+		}
+		lines = append(lines, fmt.Sprintf("| %s |", strings.Join(vals, " | ")))
+	}
+
+	_, e := fmt.Fprintln(w, strings.Join(lines, "\n"))
+
+	return e
+}
+
+// WriteParquet writes every row of the strat table to w as Parquet.  Dates are written with the DATE logical type.
+func (strt *Strat) WriteParquet(w io.Writer) error {
+	cols := strt.columns()
+	md := make([]string, len(cols))
+	for ind, c := range cols {
+		md[ind] = fmt.Sprintf("name=%s, type=%s", c.name, parquetType(c.ch))
+	}
+
+	pw, e := writer.NewCSVWriterFromWriter(md, w, 1)
+	if e != nil {
+		return e
+	}
+
+	for row := 0; row < len(strt.count); row++ {
+		rec := make([]any, len(cols))
+		for ind, c := range cols {
+			val := c.vals[row]
+			if c.field >= 0 && c.ch.Base == chutils.ChString {
+				val = strt.text(c, row, false)
+			}
+
+			if rec[ind], e = parquetValue(c.ch, val); e != nil {
+				return e
+			}
+		}
+
+		if e := pw.Write(rec); e != nil {
+			return e
+		}
+	}
+
+	return pw.WriteStop()
+}
+
+// parquetType returns the Parquet type of ch.
+func parquetType(ch chutils.ChField) string {
+	switch ch.Base {
+	case chutils.ChInt:
+		if ch.Length > 0 && ch.Length <= 32 {
+			return "INT32"
+		}
+		return "INT64"
+	case chutils.ChFloat:
+		return "DOUBLE"
+	case chutils.ChDate:
+		return "INT32, convertedtype=DATE"
+	default:
+		return "BYTE_ARRAY, convertedtype=UTF8"
+	}
+}
+
+// parquetValue converts x to the Parquet type of ch.
+func parquetValue(ch chutils.ChField, x any) (any, error) {
+	switch ch.Base {
+	case chutils.ChInt:
+		i, ok := toInt(x)
+		if !ok {
+			return nil, fmt.Errorf("parquetValue: cannot convert %v to int", x)
+		}
+
+		if ch.Length > 0 && ch.Length <= 32 {
+			return int32(i), nil
+		}
+
+		return i, nil
+	case chutils.ChFloat:
+		f, ok := toFloat(x)
+		if !ok {
+			return nil, fmt.Errorf("parquetValue: cannot convert %v to float", x)
+		}
+
+		return f, nil
+	case chutils.ChDate:
+		t, ok := x.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("parquetValue: cannot convert %v to date", x)
+		}
+
+		// days since the epoch
+		return int32(math.Floor(float64(t.Unix()) / 86400.0)), nil
+	default:
+		return format(x), nil
+	}
+}
+
+// toInt converts x to int64 if it is an integer.
+func toInt(x any) (int64, bool) {
+	switch val := x.(type) {
+	case int:
+		return int64(val), true
+	case int8:
+		return int64(val), true
+	case int16:
+		return int64(val), true
+	case int32:
+		return int64(val), true
+	case int64:
+		return val, true
+	case uint8:
+		return int64(val), true
+	case uint16:
+		return int64(val), true
+	case uint32:
+		return int64(val), true
+	case uint64:
+		return int64(val), true
+	default:
+		return 0, false
+	}
+}
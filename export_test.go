@@ -0,0 +1,69 @@
+package sampler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/invertedv/chutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrat_Write(t *testing.T) {
+	strt := &Strat{
+		fields: []string{"state", "month"},
+		types:  []chutils.ChField{{Base: chutils.ChString}, {Base: chutils.ChDate}},
+		keys: [][]any{
+			{"CA", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+			{"NY", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		count: []uint64{3000, 1000},
+		n:     4000,
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, strt.WriteCSV(buf))
+	assert.Equal(t, "state,month,count,pct,cumPct\nString,Date,Int64,Float64,Float64\n"+
+		"CA,2021-03-01,3000,75,75\nNY,2021-04-01,1000,25,100\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, strt.WriteJSON(buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Contains(t, lines[0], `{"name":"month","type":"Date"}`)
+	assert.Equal(t, `{"state":"NY","month":"2021-04-01","count":1000,"pct":25,"cumPct":100}`, lines[2])
+
+	buf.Reset()
+	assert.Nil(t, strt.WriteMarkdown(buf))
+	assert.Contains(t, buf.String(), "| CA | 2021-03-01 | 3,000 | 75.00 | 75.00 |")
+
+	buf.Reset()
+	assert.Nil(t, strt.WriteParquet(buf))
+	assert.Equal(t, "PAR1", buf.String()[:4])
+}
+
+func TestStrat_WriteLabelled(t *testing.T) {
+	strt := &Strat{
+		fields: []string{"fico"},
+		types:  []chutils.ChField{{Base: chutils.ChInt, Length: 32}},
+		keys:   [][]any{{int32(1)}, {int32(2)}},
+		count:  []uint64{10, 30},
+		n:      40,
+	}
+	strt.FieldOpts("fico", WithBins(600, 650))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, strt.WriteCSV(buf))
+	assert.Equal(t, "fico,count,pct,cumPct\nString,Int64,Float64,Float64\n\"[600,650)\",10,25,25\n>=650,30,75,100\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, strt.WriteJSON(buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Contains(t, lines[0], `{"name":"fico","type":"String"}`)
+	assert.Equal(t, `{"fico":"[600,650)","count":10,"pct":25,"cumPct":25}`, lines[1])
+
+	buf.Reset()
+	assert.Nil(t, strt.WriteParquet(buf))
+	assert.Equal(t, "PAR1", buf.String()[:4])
+}
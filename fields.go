@@ -0,0 +1,341 @@
+package sampler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+	s "github.com/invertedv/chutils/sql"
+)
+
+// Granularity is the time bucket that Date/DateTime strat fields are rolled up to.
+type Granularity int
+
+const (
+	Day     Granularity = 1 + iota // Day buckets to the date
+	Week                           // Week buckets to the Monday starting the week
+	Month                          // Month buckets to the first day of the month
+	Quarter                        // Quarter buckets to the first day of the quarter
+	Year                           // Year buckets to the first day of the year
+)
+
+// FieldOpt is the type of function that sets options on how a strat field is formed.
+type FieldOpt func(fs *fieldSpec)
+
+// fieldSpec specifies how the values of a strat field are calculated from the source data.
+type fieldSpec struct {
+	name     string      // name of the field in the source data
+	mapping  map[any]any // if not nil, recodes values of the field
+	deflt    any         // value for values of the field not in mapping
+	mapTable string      // if not empty, the table with the mapping, which is read by a subquery
+	mapFrom  string      // field in mapTable with the values of the field
+	mapTo    string      // field in mapTable with the recoded values
+	dict     string      // if not empty, the dictionary that recodes the field
+	dictAttr string      // attribute of dict with the recoded values
+	topN     int         // if > 0, keep only the topN most frequent values
+	coverage float64     // if > 0, keep only the most frequent values that together cover this fraction of rows
+	bucket   Granularity // if > 0, the time bucket of a Date/DateTime field
+	bins     []float64   // if not nil, the cut points that bin a numeric field
+
+	keep     []any // values of the field kept by topN/coverage
+	resolved bool  // true if mapTable and keep have been determined
+}
+
+// WithTopN keeps the n most frequent values of a string field.  All other values are mapped to Other.
+func WithTopN(n int) FieldOpt {
+	return func(fs *fieldSpec) {
+		fs.topN = n
+	}
+}
+
+// WithCoverage keeps the most frequent values of a string field that, together, account for at least pct of the rows.
+// All other values are mapped to Other.  pct is a fraction in (0, 1].
+func WithCoverage(pct float64) FieldOpt {
+	return func(fs *fieldSpec) {
+		fs.coverage = pct
+	}
+}
+
+// WithDateBucket rolls up the values of a Date/DateTime field to the time bucket g.
+func WithDateBucket(g Granularity) FieldOpt {
+	return func(fs *fieldSpec) {
+		fs.bucket = g
+	}
+}
+
+// WithBins bins the values of a numeric field at the cut points cuts, which must be increasing.  The value of the
+// strat field is the index of the bin, which is displayed as the bin's interval, e.g. [600,650).
+func WithBins(cuts ...float64) FieldOpt {
+	return func(fs *fieldSpec) {
+		fs.bins = cuts
+	}
+}
+
+// WithMap recodes the values of a field using m.  Values not in m are recoded to deflt, which is typically Other.
+// deflt can't be nil, since strat fields can't be NULL.  The values of m must all be of the same type as deflt.
+func WithMap(m map[any]any, deflt any) FieldOpt {
+	return func(fs *fieldSpec) {
+		fs.mapping, fs.deflt = m, deflt
+	}
+}
+
+// WithMapTable recodes the values of a field using the ClickHouse table.  The field from holds the values of the strat
+// field and to holds the values to recode them to.  Values not found in the table are recoded to deflt, which can't be
+// nil.  The mapping stays in the DB: it's read by a scalar subquery of each query that uses the field, so nothing is
+// created in the DB.
+func WithMapTable(table, from, to string, deflt any) FieldOpt {
+	return func(fs *fieldSpec) {
+		fs.mapTable, fs.mapFrom, fs.mapTo, fs.deflt = table, from, to, deflt
+	}
+}
+
+// WithDictionary recodes the values of a field to the attribute attr of the ClickHouse dictionary dict.
+// The field must be of the dictionary's key type.
+func WithDictionary(dict, attr string) FieldOpt {
+	return func(fs *fieldSpec) {
+		fs.dict, fs.dictAttr = dict, attr
+	}
+}
+
+// newFieldSpec returns a *fieldSpec for field with opts applied.
+func newFieldSpec(field string, opts ...FieldOpt) *fieldSpec {
+	fs := &fieldSpec{name: field}
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	return fs
+}
+
+// copy returns a copy of fs.  The values kept by topN/coverage are retained.
+func (fs *fieldSpec) copy() *fieldSpec {
+	fsc := *fs
+
+	return &fsc
+}
+
+// bucketed returns true if the field is restricted to its most frequent values.
+func (fs *fieldSpec) bucketed() bool {
+	return fs.topN > 0 || fs.coverage > 0.0
+}
+
+// base is the SQL expression for the field before restricting to the most frequent values.
+// src is the reference to the field in the source data.
+func (fs *fieldSpec) base(src string) string {
+	exp := src
+
+	switch {
+	case fs.dict != "":
+		exp = fmt.Sprintf("dictGet('%s', '%s', %s)", fs.dict, fs.dictAttr, exp)
+	case fs.mapTable != "":
+		m := fs.mapQuery()
+		exp = fmt.Sprintf("transform(%s, tupleElement(%s, 1), tupleElement(%s, 2), %s)", exp, m, m, literal(fs.deflt))
+	case fs.mapping != nil:
+		exp = transform(exp, fs.mapping, fs.deflt)
+	}
+
+	if fs.bins != nil {
+		conds := make([]string, len(fs.bins))
+		for ind, cut := range fs.bins {
+			conds[ind] = fmt.Sprintf("%s < %v, %d", exp, cut, ind)
+		}
+		exp = fmt.Sprintf("toInt32(multiIf(%s, %d))", strings.Join(conds, ", "), len(fs.bins))
+	}
+
+	switch fs.bucket {
+	case Day:
+		exp = fmt.Sprintf("toDate(%s)", exp)
+	case Week:
+		exp = fmt.Sprintf("toMonday(%s)", exp)
+	case Month:
+		exp = fmt.Sprintf("toStartOfMonth(%s)", exp)
+	case Quarter:
+		exp = fmt.Sprintf("toStartOfQuarter(%s)", exp)
+	case Year:
+		exp = fmt.Sprintf("toStartOfYear(%s)", exp)
+	}
+
+	return exp
+}
+
+// labelled returns true if the values of the field are formatted as labels that aren't values of its type, or are
+// recoded, so the field is exported as a string.
+func (fs *fieldSpec) labelled() bool {
+	recoded := fs.mapping != nil || fs.mapTable != "" || fs.dict != ""
+
+	return recoded || fs.bins != nil || fs.bucket == Month || fs.bucket == Quarter || fs.bucket == Year
+}
+
+// format formats x, a value of the field, for display.
+func (fs *fieldSpec) format(x any) string {
+	if bin, ok := x.(int32); ok && fs.bins != nil {
+		return fs.binLabel(int(bin))
+	}
+
+	dt, ok := x.(time.Time)
+	if !ok {
+		return format(x)
+	}
+
+	switch fs.bucket {
+	case Month:
+		return dt.Format("2006-01")
+	case Quarter:
+		return fmt.Sprintf("%dQ%d", dt.Year(), (int(dt.Month())-1)/3+1)
+	case Year:
+		return dt.Format("2006")
+	default:
+		return format(x)
+	}
+}
+
+// binLabel returns the interval of the bin-th bin.
+func (fs *fieldSpec) binLabel(bin int) string {
+	switch {
+	case bin <= 0:
+		return fmt.Sprintf("<%v", fs.bins[0])
+	case bin >= len(fs.bins):
+		return fmt.Sprintf(">=%v", fs.bins[len(fs.bins)-1])
+	default:
+		return fmt.Sprintf("[%v,%v)", fs.bins[bin-1], fs.bins[bin])
+	}
+}
+
+// expr is the SQL expression for the value of the strat field. src is the reference to the field in the source data.
+func (fs *fieldSpec) expr(src string) string {
+	exp := fs.base(src)
+
+	if fs.bucketed() && fs.resolved {
+		vals := make([]string, len(fs.keep))
+		for ind, v := range fs.keep {
+			vals[ind] = literal(v)
+		}
+		// ensure the IN list isn't empty
+		vals = append(vals, literal(Other))
+		exp = fmt.Sprintf("if(%s IN (%s), %s, '%s')", exp, strings.Join(vals, ","), exp, Other)
+	}
+
+	return exp
+}
+
+// resolve checks the recoding of the field and determines the values kept by topN/coverage from the data returned by
+// query.
+func (fs *fieldSpec) resolve(query string, conn *chutils.Connect) error {
+	if fs.resolved {
+		return nil
+	}
+
+	if (fs.mapping != nil || fs.mapTable != "") && fs.deflt == nil {
+		return fmt.Errorf("recoding field %s requires a non-nil default", fs.name)
+	}
+
+	if !fs.bucketed() {
+		fs.resolved = true
+		return nil
+	}
+
+	if fs.coverage < 0.0 || fs.coverage > 1.0 {
+		return fmt.Errorf("coverage for field %s must be in (0,1], got %v", fs.name, fs.coverage)
+	}
+
+	qry := fmt.Sprintf("SELECT %s AS v, count(*) AS n FROM (%s) GROUP BY v ORDER BY n DESC, v", fs.base(fs.name), query)
+	rdr := s.NewReader(qry, conn)
+
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		return e
+	}
+
+	rows, _, e := rdr.Read(0, false)
+	if e != nil {
+		return e
+	}
+
+	total := 0.0
+	for _, row := range rows {
+		total += float64(row[1].(uint64))
+	}
+
+	fs.keep = make([]any, 0)
+	cum := 0.0
+	for ind, row := range rows {
+		if _, ok := row[0].(string); !ok {
+			return fmt.Errorf("top-N/coverage bucketing requires a string field: %s", fs.name)
+		}
+
+		if fs.topN > 0 && ind >= fs.topN {
+			break
+		}
+
+		if fs.coverage > 0.0 && cum >= fs.coverage*total {
+			break
+		}
+
+		fs.keep = append(fs.keep, row[0])
+		cum += float64(row[1].(uint64))
+	}
+
+	fs.resolved = true
+
+	return nil
+}
+
+// mapQuery returns the scalar subquery that reads mapTable as a tuple of the from and to arrays.  The pairs are
+// sorted so that the arrays line up however often the subquery is run.
+func (fs *fieldSpec) mapQuery() string {
+	return fmt.Sprintf("(SELECT (arrayMap(x -> x.1, p), arrayMap(x -> x.2, p)) FROM (SELECT arraySort(groupArray((%s, %s))) AS p FROM %s))",
+		fs.mapFrom, fs.mapTo, fs.mapTable)
+}
+
+// transform returns the ClickHouse expression to recode src using mapping.
+func transform(src string, mapping map[any]any, deflt any) string {
+	if len(mapping) == 0 {
+		return literal(deflt)
+	}
+
+	from := make([]string, 0, len(mapping))
+	lookup := make(map[string]any)
+	for k, v := range mapping {
+		lit := literal(k)
+		from = append(from, lit)
+		lookup[lit] = v
+	}
+
+	// keep the query the same from run to run
+	sort.Strings(from)
+
+	to := make([]string, len(from))
+	for ind, f := range from {
+		to[ind] = literal(lookup[f])
+	}
+
+	return fmt.Sprintf("transform(%s, [%s], [%s], %s)", src, strings.Join(from, ","), strings.Join(to, ","), literal(deflt))
+}
+
+// literal returns x as a ClickHouse literal.
+func literal(x any) string {
+	switch val := x.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(strings.ReplaceAll(val, `\`, `\\`), "'", `\'`))
+	case time.Time:
+		if val.Hour() != 0 || val.Minute() != 0 || val.Second() != 0 {
+			return fmt.Sprintf("toDateTime('%s')", val.Format("2006-01-02 15:04:05"))
+		}
+		return fmt.Sprintf("toDate('%s')", val.Format("2006-01-02"))
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// copySpecs returns a deep copy of specs.
+func copySpecs(specs map[string]*fieldSpec) map[string]*fieldSpec {
+	cp := make(map[string]*fieldSpec)
+	for k, v := range specs {
+		cp[k] = v.copy()
+	}
+
+	return cp
+}
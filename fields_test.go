@@ -0,0 +1,55 @@
+package sampler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldSpec_expr(t *testing.T) {
+	fs := newFieldSpec("servicer", WithTopN(2))
+	assert.Equal(t, "a.servicer", fs.expr("a.servicer"))
+
+	fs.keep, fs.resolved = []any{"A", "O'Brien"}, true
+	assert.Equal(t, `if(a.servicer IN ('A','O\'Brien','__other__'), a.servicer, '__other__')`, fs.expr("a.servicer"))
+
+	strt := &Strat{Query: "SELECT * FROM t"}
+	strt.FieldOpts("servicer", WithTopN(2))
+	assert.Equal(t, "SELECT * FROM t", strt.source("servicer"))
+	strt.specs["servicer"].keep, strt.specs["servicer"].resolved = []any{"A"}, true
+	assert.Equal(t, "SELECT * REPLACE(if(servicer IN ('A','__other__'), servicer, '__other__') AS servicer) FROM (SELECT * FROM t)",
+		strt.source("servicer", "state"))
+}
+
+func TestFieldSpec_format(t *testing.T) {
+	dt := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	exp := map[Granularity]string{Day: "2021-08-01", Week: "2021-08-01", Month: "2021-08", Quarter: "2021Q3", Year: "2021"}
+	for g, fmtd := range exp {
+		fs := newFieldSpec("vintageDt", WithDateBucket(g))
+		assert.Equal(t, fmtd, fs.format(dt))
+	}
+
+	fs := newFieldSpec("vintageDt", WithDateBucket(Quarter))
+	assert.Equal(t, "toStartOfQuarter(a.vintageDt)", fs.expr("a.vintageDt"))
+}
+
+func TestFieldSpec_mapping(t *testing.T) {
+	fs := newFieldSpec("state", WithMap(map[any]any{"NY": "Northeast", "CA": "West", "NJ": "Northeast"}, Other))
+	assert.Equal(t, "transform(state, ['CA','NJ','NY'], ['West','Northeast','Northeast'], '__other__')", fs.expr("state"))
+
+	// strat fields can't be NULL
+	fs = newFieldSpec("state", WithMap(map[any]any{"NY": "Northeast"}, nil))
+	assert.NotNil(t, fs.resolve("SELECT * FROM src", nil))
+	fs = newFieldSpec("zip3", WithMapTable("ref.zips", "zip3", "msa", nil))
+	assert.NotNil(t, fs.resolve("SELECT * FROM src", nil))
+
+	// the map table is read by a subquery, so there's nothing to create
+	fs = newFieldSpec("zip3", WithMapTable("ref.zips", "zip3", "msa", "none"))
+	assert.Nil(t, fs.resolve("SELECT * FROM src", nil))
+	m := "(SELECT (arrayMap(x -> x.1, p), arrayMap(x -> x.2, p)) FROM (SELECT arraySort(groupArray((zip3, msa))) AS p FROM ref.zips))"
+	assert.Equal(t, "transform(a.zip3, tupleElement("+m+", 1), tupleElement("+m+", 2), 'none')", fs.expr("a.zip3"))
+
+	fs = newFieldSpec("prodCd", WithDictionary("products", "family"))
+	assert.Equal(t, "dictGet('products', 'family', a.prodCd)", fs.expr("a.prodCd"))
+}
@@ -0,0 +1,616 @@
+package sampler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// value is a strat value that keeps its Go type through a JSON round trip.  It is encoded as an object with a single
+// key that is the type, e.g. {"int32":700} or {"date":"2021-03-01T00:00:00Z"}.
+type value struct {
+	x any
+}
+
+func (v value) MarshalJSON() ([]byte, error) {
+	var kind string
+	switch v.x.(type) {
+	case nil:
+		return []byte("null"), nil
+	case string:
+		kind = "string"
+	case bool:
+		kind = "bool"
+	case int:
+		kind = "int"
+	case int8:
+		kind = "int8"
+	case int16:
+		kind = "int16"
+	case int32:
+		kind = "int32"
+	case int64:
+		kind = "int64"
+	case uint8:
+		kind = "uint8"
+	case uint16:
+		kind = "uint16"
+	case uint32:
+		kind = "uint32"
+	case uint64:
+		kind = "uint64"
+	case float32:
+		kind = "float32"
+	case float64:
+		kind = "float64"
+	case time.Time:
+		kind = "date"
+	default:
+		return nil, fmt.Errorf("value: unsupported type %T", v.x)
+	}
+
+	return json.Marshal(map[string]any{kind: v.x})
+}
+
+func (v *value) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		v.x = nil
+		return nil
+	}
+
+	var m map[string]json.RawMessage
+	if e := json.Unmarshal(data, &m); e != nil {
+		return e
+	}
+
+	if len(m) != 1 {
+		return fmt.Errorf("value: expected a single type, got %s", string(data))
+	}
+
+	for kind, raw := range m {
+		var e error
+		switch kind {
+		case "string":
+			var x string
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "bool":
+			var x bool
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "int":
+			var x int
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "int8":
+			var x int8
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "int16":
+			var x int16
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "int32":
+			var x int32
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "int64":
+			var x int64
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "uint8":
+			var x uint8
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "uint16":
+			var x uint16
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "uint32":
+			var x uint32
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "uint64":
+			var x uint64
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "float32":
+			var x float32
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "float64":
+			var x float64
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		case "date":
+			var x time.Time
+			e = json.Unmarshal(raw, &x)
+			v.x = x
+		default:
+			return fmt.Errorf("value: unsupported type %s", kind)
+		}
+
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// floats is a []float64 that survives a JSON round trip with nan values, such as the summary of a stratum whose column
+// is all NULL.  The elements are encoded as nanFloats.
+type floats []float64
+
+func (f floats) MarshalJSON() ([]byte, error) {
+	if f == nil {
+		return []byte("null"), nil
+	}
+
+	vals := make([]nanFloat, len(f))
+	for ind, x := range f {
+		vals[ind] = nanFloat(x)
+	}
+
+	return json.Marshal(vals)
+}
+
+func (f *floats) UnmarshalJSON(data []byte) error {
+	var vals []nanFloat
+	if e := json.Unmarshal(data, &vals); e != nil {
+		return e
+	}
+
+	if vals == nil {
+		*f = nil
+		return nil
+	}
+
+	*f = make(floats, len(vals))
+	for ind, x := range vals {
+		(*f)[ind] = float64(x)
+	}
+
+	return nil
+}
+
+// nanFloat is a float64 that's encoded as null in JSON if it's nan or +/-inf.  null is decoded as nan.
+type nanFloat float64
+
+func (f nanFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(float64(f))
+}
+
+func (f *nanFloat) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*f = nanFloat(math.NaN())
+		return nil
+	}
+
+	return json.Unmarshal(data, (*float64)(f))
+}
+
+func toValues(x []any) []value {
+	if x == nil {
+		return nil
+	}
+
+	vals := make([]value, len(x))
+	for ind, xv := range x {
+		vals[ind] = value{xv}
+	}
+
+	return vals
+}
+
+func fromValues(vals []value) []any {
+	if vals == nil {
+		return nil
+	}
+
+	x := make([]any, len(vals))
+	for ind, v := range vals {
+		x[ind] = v.x
+	}
+
+	return x
+}
+
+func toKeys(keys [][]any) [][]value {
+	if keys == nil {
+		return nil
+	}
+
+	vals := make([][]value, len(keys))
+	for ind, key := range keys {
+		vals[ind] = toValues(key)
+	}
+
+	return vals
+}
+
+func fromKeys(vals [][]value) [][]any {
+	if vals == nil {
+		return nil
+	}
+
+	keys := make([][]any, len(vals))
+	for ind, v := range vals {
+		keys[ind] = fromValues(v)
+	}
+
+	return keys
+}
+
+// specJSON is the JSON representation of a fieldSpec.
+type specJSON struct {
+	Name     string      `json:"name"`
+	Mapping  [][2]value  `json:"mapping,omitempty"`
+	Default  value       `json:"default"`
+	MapTable string      `json:"mapTable,omitempty"`
+	MapFrom  string      `json:"mapFrom,omitempty"`
+	MapTo    string      `json:"mapTo,omitempty"`
+	Dict     string      `json:"dict,omitempty"`
+	DictAttr string      `json:"dictAttr,omitempty"`
+	TopN     int         `json:"topN,omitempty"`
+	Coverage float64     `json:"coverage,omitempty"`
+	Bucket   Granularity `json:"bucket,omitempty"`
+	Bins     []float64   `json:"bins,omitempty"`
+	Keep     []value     `json:"keep,omitempty"`
+	Resolved bool        `json:"resolved"`
+}
+
+func toSpecs(specs map[string]*fieldSpec) map[string]*specJSON {
+	if specs == nil {
+		return nil
+	}
+
+	js := make(map[string]*specJSON)
+	for fld, fs := range specs {
+		sj := &specJSON{Name: fs.name, Default: value{fs.deflt}, MapTable: fs.mapTable, MapFrom: fs.mapFrom,
+			MapTo: fs.mapTo, Dict: fs.dict, DictAttr: fs.dictAttr, TopN: fs.topN, Coverage: fs.coverage,
+			Bucket: fs.bucket, Bins: fs.bins, Keep: toValues(fs.keep), Resolved: fs.resolved}
+
+		// the mapping is sorted so the JSON is the same from run to run
+		for k, v := range fs.mapping {
+			sj.Mapping = append(sj.Mapping, [2]value{{k}, {v}})
+		}
+		sort.Slice(sj.Mapping, func(i, j int) bool { return literal(sj.Mapping[i][0].x) < literal(sj.Mapping[j][0].x) })
+
+		js[fld] = sj
+	}
+
+	return js
+}
+
+func fromSpecs(js map[string]*specJSON) map[string]*fieldSpec {
+	if js == nil {
+		return nil
+	}
+
+	specs := make(map[string]*fieldSpec)
+	for fld, sj := range js {
+		fs := &fieldSpec{name: sj.Name, deflt: sj.Default.x, mapTable: sj.MapTable, mapFrom: sj.MapFrom, mapTo: sj.MapTo,
+			dict: sj.Dict, dictAttr: sj.DictAttr, topN: sj.TopN, coverage: sj.Coverage, bucket: sj.Bucket, bins: sj.Bins,
+			keep: fromValues(sj.Keep), resolved: sj.Resolved}
+
+		if sj.Mapping != nil {
+			fs.mapping = make(map[any]any)
+			for _, kv := range sj.Mapping {
+				fs.mapping[kv[0].x] = kv[1].x
+			}
+		}
+
+		specs[fld] = fs
+	}
+
+	return specs
+}
+
+// summaryJSON is the JSON representation of a Summary.
+type summaryJSON struct {
+	Column   string  `json:"column"`
+	Stat     Stat    `json:"stat"`
+	Quantile float64 `json:"quantile,omitempty"`
+	Values   floats  `json:"values,omitempty"`
+	Raw      floats  `json:"raw,omitempty"`
+}
+
+func toSummaries(sms []*Summary) []*summaryJSON {
+	js := make([]*summaryJSON, len(sms))
+	for ind, sm := range sms {
+		js[ind] = &summaryJSON{Column: sm.Column, Stat: sm.Stat, Quantile: sm.Quantile, Values: sm.Values, Raw: sm.raw}
+	}
+
+	return js
+}
+
+func fromSummaries(js []*summaryJSON) []*Summary {
+	sms := make([]*Summary, len(js))
+	for ind, sj := range js {
+		sms[ind] = &Summary{Column: sj.Column, Stat: sj.Stat, Quantile: sj.Quantile, Values: sj.Values, raw: sj.Raw}
+	}
+
+	return sms
+}
+
+// subtotalJSON is the JSON representation of a subtotal.
+type subtotalJSON struct {
+	Present []bool   `json:"present"`
+	Key     []value  `json:"key"`
+	Count   uint64   `json:"count"`
+	Measure nanFloat `json:"measure,omitempty"`
+}
+
+// stratJSON is the JSON representation of a Strat.
+type stratJSON struct {
+	Query        string               `json:"query"`
+	Fields       []string             `json:"fields"`
+	Types        []chutils.ChField    `json:"types"`
+	Keys         [][]value            `json:"keys"`
+	Count        []uint64             `json:"count"`
+	N            uint64               `json:"n"`
+	MinCount     int                  `json:"minCount"`
+	Collapse     CollapsePolicy       `json:"collapse"`
+	SortByCounts bool                 `json:"sortByCounts"`
+	Specs        map[string]*specJSON `json:"specs,omitempty"`
+	Measure      string               `json:"measure,omitempty"`
+	Measures     floats               `json:"measures,omitempty"`
+	Summaries    []*summaryJSON       `json:"summaries,omitempty"`
+	Rollup       bool                 `json:"rollup,omitempty"`
+	KeepLast     bool                 `json:"keepLast,omitempty"`
+	Subtotals    []*subtotalJSON      `json:"subtotals,omitempty"`
+	RawKeys      [][]value            `json:"rawKeys"`
+	RawCount     []uint64             `json:"rawCount"`
+	RawMeasures  floats               `json:"rawMeasures,omitempty"`
+	Group        []int                `json:"group"`
+	DropKeys     [][]value            `json:"dropKeys,omitempty"`
+	DropCount    []uint64             `json:"dropCount,omitempty"`
+	DropMeasures floats               `json:"dropMeasures,omitempty"`
+}
+
+// MarshalJSON encodes the strat, including its definition and results.  The values of the strat fields keep their
+// types, so dates and integers are restored as such by UnmarshalJSON.
+func (strt *Strat) MarshalJSON() ([]byte, error) {
+	sj := &stratJSON{
+		Query:        strt.Query,
+		Fields:       strt.fields,
+		Types:        strt.types,
+		Keys:         toKeys(strt.keys),
+		Count:        strt.count,
+		N:            strt.n,
+		MinCount:     strt.minCount,
+		Collapse:     strt.collapse,
+		SortByCounts: strt.sortByCounts,
+		Specs:        toSpecs(strt.specs),
+		Measure:      strt.measure,
+		Measures:     strt.measures,
+		Summaries:    toSummaries(strt.summaries),
+		Rollup:       strt.rollup,
+		KeepLast:     strt.keepLast,
+		RawKeys:      toKeys(strt.rawKeys),
+		RawCount:     strt.rawCount,
+		RawMeasures:  strt.rawMeasures,
+		Group:        strt.group,
+		DropKeys:     toKeys(strt.dropKeys),
+		DropCount:    strt.dropCount,
+		DropMeasures: strt.dropMeasures,
+	}
+
+	for _, st := range strt.subtotals {
+		sj.Subtotals = append(sj.Subtotals, &subtotalJSON{Present: st.present, Key: toValues(st.key), Count: st.count,
+			Measure: nanFloat(st.measure)})
+	}
+
+	return json.Marshal(sj)
+}
+
+// UnmarshalJSON decodes a strat encoded by MarshalJSON.  The strat has no DB connection; use SetConnect to add one.
+func (strt *Strat) UnmarshalJSON(data []byte) error {
+	sj := &stratJSON{}
+	if e := json.Unmarshal(data, sj); e != nil {
+		return e
+	}
+
+	*strt = Strat{
+		Query:        sj.Query,
+		fields:       sj.Fields,
+		types:        sj.Types,
+		keys:         fromKeys(sj.Keys),
+		count:        sj.Count,
+		n:            sj.N,
+		minCount:     sj.MinCount,
+		collapse:     sj.Collapse,
+		sortByCounts: sj.SortByCounts,
+		specs:        fromSpecs(sj.Specs),
+		measure:      sj.Measure,
+		measures:     sj.Measures,
+		summaries:    fromSummaries(sj.Summaries),
+		rollup:       sj.Rollup,
+		keepLast:     sj.KeepLast,
+		rawKeys:      fromKeys(sj.RawKeys),
+		rawCount:     sj.RawCount,
+		rawMeasures:  sj.RawMeasures,
+		group:        sj.Group,
+		dropKeys:     fromKeys(sj.DropKeys),
+		dropCount:    sj.DropCount,
+		dropMeasures: sj.DropMeasures,
+	}
+
+	for _, st := range sj.Subtotals {
+		strt.subtotals = append(strt.subtotals, &subtotal{present: st.Present, key: fromValues(st.Key), count: st.Count,
+			measure: float64(st.Measure)})
+	}
+
+	return nil
+}
+
+// SetConnect sets the DB connection, e.g. after the strat is restored by UnmarshalJSON.
+func (strt *Strat) SetConnect(conn *chutils.Connect) {
+	strt.conn = conn
+}
+
+// generatorJSON is the JSON representation of a Generator.
+type generatorJSON struct {
+	Query          string               `json:"query"`
+	SampleTable    string               `json:"sampleTable"`
+	StratTable     string               `json:"stratTable"`
+	TargetTotal    int                  `json:"targetTotal"`
+	MinCount       uint64               `json:"minCount"`
+	Collapse       CollapsePolicy       `json:"collapse"`
+	SampleCap      float64              `json:"sampleCap"`
+	SortByCount    bool                 `json:"sortByCount"`
+	Specs          map[string]*specJSON `json:"specs,omitempty"`
+	Measure        string               `json:"measure,omitempty"`
+	BalanceMeasure bool                 `json:"balanceMeasure,omitempty"`
+	Summaries      []*summaryJSON       `json:"summaries,omitempty"`
+	Rollup         bool                 `json:"rollup,omitempty"`
+	Fields         []string             `json:"fields,omitempty"`
+	Seed           int64                `json:"seed,omitempty"`
+	Splits         []Split              `json:"splits,omitempty"`
+	AuditTable     string               `json:"auditTable,omitempty"`
+	HashKey        []string             `json:"hashKey,omitempty"`
+	Salt           string               `json:"salt,omitempty"`
+	Tiers          []int                `json:"tiers,omitempty"`
+	TierBase       int                  `json:"tierBase,omitempty"`
+	ExcludeSource  string               `json:"excludeSource,omitempty"`
+	ExcludeKeys    []string             `json:"excludeKeys,omitempty"`
+	TopUpTol       float64              `json:"topUpTol,omitempty"`
+	TopUpKeys      []string             `json:"topUpKeys,omitempty"`
+	ClassLabel     string               `json:"classLabel,omitempty"`
+	ClassRatio     []float64            `json:"classRatio,omitempty"`
+	PPSSize        string               `json:"ppsSize,omitempty"`
+	Partitions     []string             `json:"partitions,omitempty"`
+
+	SampleRates  floats      `json:"sampleRates,omitempty"`
+	Strats       *Strat      `json:"strats,omitempty"`
+	SampleStrats *Strat      `json:"sampleStrats,omitempty"`
+	ExpCaptured  int         `json:"expCaptured"`
+	ExpMeasure   float64     `json:"expMeasure,omitempty"`
+	Trace        []Iteration `json:"trace,omitempty"`
+	TierRates    [][]float64 `json:"tierRates,omitempty"`
+	Excluded     *Strat      `json:"excluded,omitempty"`
+	TopUpObs     int         `json:"topUpObs,omitempty"`
+	ActCaptured  int         `json:"actCaptured"`
+	MakeQuery    string      `json:"makeQuery,omitempty"`
+}
+
+// MarshalJSON encodes the generator, including the plan calculated by CalcRates.  A generator restored by
+// UnmarshalJSON can run MakeTable without re-running CalcRates.
+func (gn *Generator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&generatorJSON{
+		Query:          gn.Query,
+		SampleTable:    gn.sampleTable,
+		StratTable:     gn.stratTable,
+		TargetTotal:    gn.targetTotal,
+		MinCount:       gn.minCount,
+		Collapse:       gn.collapse,
+		SampleCap:      gn.sampleCap,
+		SortByCount:    gn.sortByCount,
+		Specs:          toSpecs(gn.specs),
+		Measure:        gn.measure,
+		BalanceMeasure: gn.balanceMeasure,
+		Summaries:      toSummaries(copySummaries(gn.summaries)),
+		Rollup:         gn.rollup,
+		Fields:         gn.fields,
+		Seed:           gn.seed,
+		Splits:         gn.splits,
+		AuditTable:     gn.auditTable,
+		HashKey:        gn.hashKey,
+		Salt:           gn.salt,
+		Tiers:          gn.tiers,
+		TierBase:       gn.tierBase,
+		ExcludeSource:  gn.excludeSource,
+		ExcludeKeys:    gn.excludeKeys,
+		TopUpTol:       gn.topUpTol,
+		TopUpKeys:      gn.topUpKeys,
+		ClassLabel:     gn.classLabel,
+		ClassRatio:     gn.classRatio,
+		PPSSize:        gn.ppsSize,
+		Partitions:     gn.partitions,
+		SampleRates:    gn.sampleRate,
+		Strats:         gn.strats,
+		SampleStrats:   gn.sampleStrats,
+		ExpCaptured:    gn.expCaptured,
+		ExpMeasure:     gn.expMeasure,
+		Trace:          gn.trace,
+		TierRates:      gn.tierRates,
+		Excluded:       gn.excluded,
+		TopUpObs:       gn.topUpObs,
+		ActCaptured:    gn.actCaptured,
+		MakeQuery:      gn.makeQuery,
+	})
+}
+
+// UnmarshalJSON decodes a generator encoded by MarshalJSON. The generator has no DB connection; use SetConnect
+// to add one.
+func (gn *Generator) UnmarshalJSON(data []byte) error {
+	gj := &generatorJSON{}
+	if e := json.Unmarshal(data, gj); e != nil {
+		return e
+	}
+
+	*gn = Generator{
+		Query:          gj.Query,
+		sampleTable:    gj.SampleTable,
+		stratTable:     gj.StratTable,
+		targetTotal:    gj.TargetTotal,
+		minCount:       gj.MinCount,
+		collapse:       gj.Collapse,
+		sampleCap:      gj.SampleCap,
+		sortByCount:    gj.SortByCount,
+		specs:          fromSpecs(gj.Specs),
+		measure:        gj.Measure,
+		balanceMeasure: gj.BalanceMeasure,
+		summaries:      fromSummaries(gj.Summaries),
+		rollup:         gj.Rollup,
+		fields:         gj.Fields,
+		seed:           gj.Seed,
+		splits:         gj.Splits,
+		auditTable:     gj.AuditTable,
+		hashKey:        gj.HashKey,
+		salt:           gj.Salt,
+		tiers:          gj.Tiers,
+		tierBase:       gj.TierBase,
+		excludeSource:  gj.ExcludeSource,
+		excludeKeys:    gj.ExcludeKeys,
+		topUpTol:       gj.TopUpTol,
+		topUpKeys:      gj.TopUpKeys,
+		classLabel:     gj.ClassLabel,
+		classRatio:     gj.ClassRatio,
+		ppsSize:        gj.PPSSize,
+		partitions:     gj.Partitions,
+		sampleRate:     gj.SampleRates,
+		strats:         gj.Strats,
+		sampleStrats:   gj.SampleStrats,
+		expCaptured:    gj.ExpCaptured,
+		expMeasure:     gj.ExpMeasure,
+		trace:          gj.Trace,
+		tierRates:      gj.TierRates,
+		excluded:       gj.Excluded,
+		topUpObs:       gj.TopUpObs,
+		actCaptured:    gj.ActCaptured,
+		makeQuery:      gj.MakeQuery,
+	}
+
+	return nil
+}
+
+// SetConnect sets the DB connection of the generator and its strats, e.g. after the generator is restored by
+// UnmarshalJSON.
+func (gn *Generator) SetConnect(conn *chutils.Connect) {
+	gn.conn = conn
+	for _, strt := range []*Strat{gn.strats, gn.sampleStrats, gn.excluded} {
+		if strt != nil {
+			strt.SetConnect(conn)
+		}
+	}
+}
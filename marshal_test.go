@@ -0,0 +1,64 @@
+package sampler
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/invertedv/chutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_MarshalJSON(t *testing.T) {
+	dt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	strt := &Strat{
+		Query:       "SELECT * FROM bk.loans",
+		fields:      []string{"month", "fico"},
+		keys:        [][]any{{dt, int32(700)}, {Other, Other}},
+		count:       []uint64{3000, 1000},
+		n:           4000,
+		minCount:    100,
+		collapse:    CollapseOther,
+		rawKeys:     [][]any{{dt, int32(700)}, {dt, int32(500)}, {dt, int32(550)}},
+		rawCount:    []uint64{3000, 50, 950},
+		group:       []int{0, 1, 1},
+		specs:       map[string]*fieldSpec{"month": newFieldSpec("month", WithDateBucket(Month))},
+		summaries:   []*Summary{{Column: "upb", Stat: StatMean, Values: []float64{1, math.NaN()}, raw: []float64{1, 2, 3}}},
+		measure:     "sum(upb)",
+		measures:    []float64{10, 20},
+		rawMeasures: []float64{10, 5, 15},
+	}
+
+	gn := NewGenerator(strt.Query, "tmp.sample", "tmp.strat", 2000, false, nil)
+	gn.strats, gn.sampleRate, gn.expCaptured = strt, []float64{0.33, 1.0}, 2000
+
+	js, e := json.Marshal(gn)
+	assert.Nil(t, e)
+
+	gnr := &Generator{}
+	assert.Nil(t, json.Unmarshal(js, gnr))
+	assert.Equal(t, gn.sampleRate, gnr.sampleRate)
+	assert.Equal(t, gn.targetTotal, gnr.targetTotal)
+	assert.Equal(t, strt.keys, gnr.strats.keys)
+	assert.Equal(t, strt.rawKeys, gnr.strats.rawKeys)
+	assert.IsType(t, int32(0), gnr.strats.keys[0][1])
+	assert.Equal(t, "2021-03", gnr.strats.format(0, gnr.strats.keys[0][0]))
+	assert.Equal(t, []float64{1, 2, 3}, gnr.strats.summaries[0].raw)
+
+	// a NULL summary is nan, which is null in JSON
+	assert.Contains(t, string(js), `"values":[1,null]`)
+	assert.True(t, math.IsNaN(gnr.strats.summaries[0].Values[1]))
+
+	// the round trip is exact
+	js2, e := json.Marshal(gnr)
+	assert.Nil(t, e)
+	assert.JSONEq(t, string(js), string(js2))
+
+	// every Strat gets the connection
+	gnr.excluded = NewStrat(gn.Query, nil, false)
+	conn := &chutils.Connect{}
+	gnr.SetConnect(conn)
+	assert.Equal(t, conn, gnr.strats.conn)
+	assert.Equal(t, conn, gnr.excluded.conn)
+}
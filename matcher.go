@@ -0,0 +1,235 @@
+package sampler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/invertedv/chutils"
+)
+
+// Matcher pairs case rows with control rows from the same stratum.  Each case is matched to k controls drawn at
+// random from the controls of its stratum.  The cases are the rows of Query for which the case predicate is true.
+// The other rows, including those for which it's NULL, are the controls.
+// The draws are a hash of the row and a seed (see Seed), so the match sets are reproducible.
+type Matcher struct {
+	Query      string                // Query to fetch the cases and controls
+	matchTable string                // table to create with the matched rows
+	casePred   string                // predicate that is true for cases, such as "treated = 1"
+	k          int                   // number of controls per case
+	specs      map[string]*fieldSpec // options for forming the values of strat fields
+	seed       int64                 // seed of the hash that orders the rows within their stratum
+	keys       []string              // if not nil, columns that identify a row, which are hashed to order the rows
+
+	// calculated fields
+	strats   *Strat           // strats of Query
+	cases    *Strat           // strats of the cases
+	controls *Strat           // strats of the controls
+	conn     *chutils.Connect // connection to DB
+}
+
+// Shortfall is a stratum that doesn't have enough controls for its cases.
+type Shortfall struct {
+	Stratum  string // Stratum is the label of the stratum
+	Cases    uint64 // Cases is the number of cases in the stratum
+	Controls uint64 // Controls is the number of controls in the stratum, which is less than k*Cases
+}
+
+// NewMatcher returns a *Matcher.
+// query is the CH query to fetch the cases and controls.
+// matchTable is the output table of matched rows.
+// casePred is a CH expression that is true for cases.  Rows for which it's false or NULL are controls.
+// k is the number of controls to match to each case.
+func NewMatcher(query, matchTable, casePred string, k int, conn *chutils.Connect) *Matcher {
+	return &Matcher{
+		Query:      query,
+		matchTable: matchTable,
+		casePred:   casePred,
+		k:          k,
+		conn:       conn,
+	}
+}
+
+// FieldOpts sets options on how the values of a strat field are formed.
+func (m *Matcher) FieldOpts(field string, opts ...FieldOpt) {
+	if m.specs == nil {
+		m.specs = make(map[string]*fieldSpec)
+	}
+
+	m.specs[field] = newFieldSpec(field, opts...)
+}
+
+// Seed returns (and optionally sets) the seed of the hash that orders the cases and controls within their stratum,
+// which determines the match sets.  keys, if given, are the columns that identify a row and the hash is of these, so
+// the match sets don't depend on the order of the rows.  Otherwise, the hash is of the row number, so the match sets
+// are reproducible if the rows of Query are returned in the same order.
+// The value is not updated if seed < 0.
+func (m *Matcher) Seed(seed int64, keys ...string) int64 {
+	if seed < 0 {
+		return m.seed
+	}
+
+	m.seed = seed
+	if len(keys) > 0 {
+		m.keys = keys
+	}
+
+	return m.seed
+}
+
+// Make makes the strats of the cases and controls on fields.  The values of fields are formed from all of Query, so the
+// cases and controls have the same strata.
+func (m *Matcher) Make(fields ...string) error {
+	if m.k < 1 {
+		return fmt.Errorf("(*Matcher) Make: k must be at least 1, got %d", m.k)
+	}
+
+	if len(fields) == 0 {
+		return fmt.Errorf("(*Matcher) Make: must specify strat fields")
+	}
+
+	m.strats = NewStrat(m.Query, m.conn, false)
+	m.strats.specs = copySpecs(m.specs)
+	if e := m.strats.Make(fields...); e != nil {
+		return e
+	}
+
+	m.cases, m.controls = m.splitStrats()
+	for _, strt := range []*Strat{m.cases, m.controls} {
+		if e := strt.Make(fields...); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// splitStrats returns the Strats of the cases and of the controls.  They have the specs of m.strats, which are resolved
+// from all of Query.
+func (m *Matcher) splitStrats() (cases, controls *Strat) {
+	cases = NewStrat(fmt.Sprintf("SELECT * FROM (%s) WHERE %s", m.Query, m.isCase()), m.conn, false)
+	controls = NewStrat(fmt.Sprintf("SELECT * FROM (%s) WHERE NOT %s", m.Query, m.isCase()), m.conn, false)
+	cases.specs, controls.specs = copySpecs(m.strats.specs), copySpecs(m.strats.specs)
+
+	return cases, controls
+}
+
+// Cases returns the strats of the cases.
+func (m *Matcher) Cases() *Strat {
+	return m.cases
+}
+
+// Controls returns the strats of the controls.
+func (m *Matcher) Controls() *Strat {
+	return m.controls
+}
+
+// MakeTable creates matchTable.  It has the columns of Query plus
+//   - isCase: 1 for cases, 0 for controls;
+//   - stratumId: a hash of the stratum of the row;
+//   - matchNum: the number of the case within the stratum that the row is in the match set of;
+//   - matchSet: the ID of the match set, which is a hash of stratumId and matchNum.
+//
+// Every case is in the table.  A case in a stratum that ran out of controls has fewer than k controls in its match
+// set (see Shortfalls).  Controls that aren't matched are not in the table.
+// timeOut is the query time out in minutes.
+func (m *Matcher) MakeTable(timeOut int64) error {
+	if m.strats == nil {
+		return fmt.Errorf("(*Matcher) MakeTable: must run Make first")
+	}
+
+	chutils.WithTimeOut(timeOut)(m.conn)
+
+	return makeTable(m.matchQuery(), m.matchTable, m.conn)
+}
+
+// matchQuery returns the query that makes the match sets.  The cases and controls are each numbered within their
+// stratum in the order of a hash of the row and the seed. The ith case is matched with controls (i-1)*k+1 through i*k.
+func (m *Matcher) matchQuery() string {
+	exprs := make([]string, len(m.strats.fields))
+	for ind, f := range m.strats.fields {
+		exprs[ind] = m.strats.spec(f).expr("a." + f)
+	}
+	sid := fmt.Sprintf("cityHash64(%s)", strings.Join(exprs, ", "))
+
+	cases := fmt.Sprintf("SELECT\n  a.*,\n  toUInt8(1) AS isCase,\n  %s AS stratumId,\n"+
+		"  row_number() OVER (PARTITION BY stratumId ORDER BY %s) AS matchNum\nFROM\n  (%s) AS a\nWHERE %s",
+		sid, m.order(), m.Query, m.isCase())
+	controls := fmt.Sprintf("SELECT\n  a.*,\n  toUInt8(0) AS isCase,\n  %s AS stratumId,\n"+
+		"  intDiv(row_number() OVER (PARTITION BY stratumId ORDER BY %s) - 1, %d) + 1 AS matchNum\nFROM\n  (%s) AS a\nWHERE NOT %s",
+		sid, m.order(), m.k, m.Query, m.isCase())
+
+	both := fmt.Sprintf("SELECT\n  *,\n  countIf(isCase = 1) OVER (PARTITION BY stratumId) AS nCases\nFROM (\n%s\nUNION ALL\n%s\n)",
+		cases, controls)
+
+	return fmt.Sprintf("SELECT\n  * EXCEPT (nCases),\n  cityHash64(stratumId, matchNum) AS matchSet\nFROM (\n%s\n)\nWHERE matchNum <= nCases", both)
+}
+
+// isCase returns the condition that a row is a case.  A NULL case predicate is taken as false, so the row is a control.
+func (m *Matcher) isCase() string {
+	return fmt.Sprintf("ifNull((%s), 0)", m.casePred)
+}
+
+// order returns the expression that orders the rows of a stratum: a hash of the keys, or the row number, and the seed.
+func (m *Matcher) order() string {
+	if m.keys == nil {
+		return fmt.Sprintf("cityHash64(rowNumberInAllBlocks(), %d)", m.seed)
+	}
+
+	keys := make([]string, len(m.keys))
+	for ind, k := range m.keys {
+		keys[ind] = "a." + k
+	}
+
+	return fmt.Sprintf("cityHash64(%s, %d)", strings.Join(keys, ", "), m.seed)
+}
+
+// Shortfalls returns the strata whose controls ran out, i.e. that have fewer than k controls per case.
+func (m *Matcher) Shortfalls() []Shortfall {
+	if m.cases == nil {
+		return nil
+	}
+
+	ctl := make(map[string]uint64)
+	for ind, key := range m.controls.keys {
+		ctl[keyString(key)] = m.controls.count[ind]
+	}
+
+	short := make([]Shortfall, 0)
+	for ind, key := range m.cases.keys {
+		c := ctl[keyString(key)]
+		if c < uint64(m.k)*m.cases.count[ind] {
+			short = append(short, Shortfall{Stratum: m.cases.label(key), Cases: m.cases.count[ind], Controls: c})
+		}
+	}
+
+	return short
+}
+
+func (m *Matcher) String() string {
+	str := fmt.Sprintf("Match Table: %s\nCase Predicate: %s\nControls per Case: %d\n", m.matchTable, m.casePred, m.k)
+	if m.cases == nil {
+		return str
+	}
+
+	str = fmt.Sprintf("%s# Cases: %s\n# Controls: %s\n", str, humanize.Comma(int64(m.cases.n)), humanize.Comma(int64(m.controls.n)))
+
+	short := m.Shortfalls()
+	if len(short) == 0 {
+		return fmt.Sprintf("%s\nAll strata have enough controls\n", str)
+	}
+
+	width := len("Stratum")
+	for _, sh := range short {
+		width = Max(width, len(sh.Stratum))
+	}
+
+	str = fmt.Sprintf("%s\nStrata Short of Controls\n%s%15s%15s%15s\n", str, padder("Stratum", width+4, true), "Cases",
+		"Controls", "Needed")
+	for _, sh := range short {
+		str = fmt.Sprintf("%s%s%15s%15s%15s\n", str, padder(sh.Stratum, width+4, true), humanize.Comma(int64(sh.Cases)),
+			humanize.Comma(int64(sh.Controls)), humanize.Comma(int64(uint64(m.k)*sh.Cases)))
+	}
+
+	return str
+}
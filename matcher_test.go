@@ -0,0 +1,59 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatcher_Shortfalls(t *testing.T) {
+	m := NewMatcher("SELECT * FROM src", "matched", "treated = 1", 2, nil)
+	m.strats = NewStrat(m.Query, nil, false)
+	m.strats.fields = []string{"state"}
+	m.cases = NewStrat(m.Query, nil, false)
+	m.cases.fields = []string{"state"}
+	m.cases.keys, m.cases.count, m.cases.n = [][]any{{"TX"}, {"VT"}, {"WY"}}, []uint64{10, 5, 1}, 16
+	m.controls = NewStrat(m.Query, nil, false)
+	m.controls.fields = []string{"state"}
+	m.controls.keys, m.controls.count, m.controls.n = [][]any{{"TX"}, {"VT"}}, []uint64{100, 9}, 109
+
+	assert.Equal(t, []Shortfall{{Stratum: "VT", Cases: 5, Controls: 9}, {Stratum: "WY", Cases: 1, Controls: 0}}, m.Shortfalls())
+	assert.Contains(t, m.String(), "Strata Short of Controls")
+
+	qry := m.matchQuery()
+	assert.Contains(t, qry, "cityHash64(a.state) AS stratumId")
+	assert.Contains(t, qry, "intDiv(row_number() OVER (PARTITION BY stratumId ORDER BY cityHash64(rowNumberInAllBlocks(), 0)) - 1, 2) + 1 AS matchNum")
+	assert.Contains(t, qry, "WHERE ifNull((treated = 1), 0)")
+	assert.Contains(t, qry, "WHERE NOT ifNull((treated = 1), 0)")
+	assert.Contains(t, qry, "WHERE matchNum <= nCases")
+
+	// with keys, the order doesn't depend on the order of the rows
+	assert.Equal(t, int64(7), m.Seed(7, "loanId"))
+	assert.Equal(t, int64(7), m.Seed(-1))
+	assert.Contains(t, m.matchQuery(), "row_number() OVER (PARTITION BY stratumId ORDER BY cityHash64(a.loanId, 7)) AS matchNum")
+}
+
+func TestMatcher_splitStrats(t *testing.T) {
+	m := NewMatcher("SELECT * FROM src", "matched", "treated = 1", 2, nil)
+	m.FieldOpts("servicer", WithTopN(2))
+	m.strats = NewStrat(m.Query, nil, false)
+	m.strats.specs = copySpecs(m.specs)
+
+	// the values kept are resolved from all of Query
+	m.strats.specs["servicer"].keep, m.strats.specs["servicer"].resolved = []any{"A", "B"}, true
+
+	cases, controls := m.splitStrats()
+	// rows with a NULL predicate are controls
+	assert.Equal(t, "SELECT * FROM (SELECT * FROM src) WHERE ifNull((treated = 1), 0)", cases.Query)
+	assert.Equal(t, "SELECT * FROM (SELECT * FROM src) WHERE NOT ifNull((treated = 1), 0)", controls.Query)
+
+	for _, strt := range []*Strat{cases, controls} {
+		assert.True(t, strt.spec("servicer").resolved)
+		assert.Equal(t, []any{"A", "B"}, strt.spec("servicer").keep)
+		assert.Contains(t, strt.source("servicer"), "if(servicer IN ('A','B','__other__'), servicer, '__other__') AS servicer")
+	}
+
+	// the specs are copies
+	cases.specs["servicer"].keep = nil
+	assert.Equal(t, []any{"A", "B"}, controls.spec("servicer").keep)
+}
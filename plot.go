@@ -0,0 +1,215 @@
+package sampler
+
+import (
+	"fmt"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+	"github.com/invertedv/utilities"
+)
+
+// PlotOpt is the type of function that sets options for plots.
+type PlotOpt func(po *plotOpts)
+
+type plotOpts struct {
+	logScale bool    // if true, the y-axis is log scale
+	height   float64 // height of the plot in pixels
+	width    float64 // width of the plot in pixels
+}
+
+// WithLogScale plots the y-axis on a log scale.
+func WithLogScale() PlotOpt {
+	return func(po *plotOpts) {
+		po.logScale = true
+	}
+}
+
+// WithSize sets the height and width of the plot in pixels.  The default is 1200 x 1600.
+func WithSize(height, width float64) PlotOpt {
+	return func(po *plotOpts) {
+		po.height, po.width = height, width
+	}
+}
+
+func newPlotOpts(opts ...PlotOpt) *plotOpts {
+	po := &plotOpts{height: 1200, width: 1600}
+	for _, opt := range opts {
+		opt(po)
+	}
+
+	return po
+}
+
+// layout adds the options to lay, which may be nil.
+func (po *plotOpts) layout(lay *grob.Layout) *grob.Layout {
+	if !po.logScale {
+		return lay
+	}
+
+	if lay == nil {
+		lay = &grob.Layout{}
+	}
+	lay.Yaxis = &grob.LayoutYaxis{Type: grob.LayoutYaxisTypeLog}
+
+	return lay
+}
+
+// plot produces fig with the plot options.
+func (po *plotOpts) plot(fig *grob.Fig, lay *grob.Layout, title, xTitle, yTitle string, legend bool,
+	outDir, outFile string, imageTypes []utilities.PlotlyImage, show bool) error {
+	return utilities.Plotter(fig, po.layout(lay), &utilities.PlotDef{
+		Show:       show,
+		Title:      title,
+		XTitle:     xTitle,
+		YTitle:     yTitle,
+		STitle:     "",
+		Legend:     legend,
+		Height:     po.height,
+		Width:      po.width,
+		ImageTypes: imageTypes,
+		OutDir:     outDir,
+		FileName:   outFile,
+	})
+}
+
+// PlotHeatmap plots the counts of the strat as a heatmap of rowField by colField.
+func (strt *Strat) PlotHeatmap(rowField, colField, outDir, outFile string, imageTypes []utilities.PlotlyImage, show bool,
+	opts ...PlotOpt) error {
+	ct, e := strt.Crosstab(rowField, colField)
+	if e != nil {
+		return e
+	}
+
+	return ct.Plot(outDir, outFile, imageTypes, show, opts...)
+}
+
+// PlotTree plots the hierarchy of the strat fields as a sunburst or, if treemap is true, a treemap.
+// The hierarchy follows the order of the strat fields.
+func (strt *Strat) PlotTree(treemap bool, outDir, outFile string, imageTypes []utilities.PlotlyImage, show bool,
+	opts ...PlotOpt) error {
+	root := strt.hierarchy()
+	ids, labels, parents, values := make([]string, 0), make([]string, 0), make([]string, 0), make([]uint64, 0)
+
+	var walk func(node *Node, id, parent string, depth int)
+	walk = func(node *Node, id, parent string, depth int) {
+		lbl := "Total"
+		if depth > 0 {
+			lbl = strt.format(depth-1, node.Value)
+		}
+
+		ids, labels, parents, values = append(ids, id), append(labels, lbl), append(parents, parent), append(values, node.Count)
+		for _, child := range node.Children {
+			walk(child, fmt.Sprintf("%s:%s", id, strt.format(depth, child.Value)), id, depth+1)
+		}
+	}
+	walk(root, "Total", "", 0)
+
+	var tr grob.Trace = &grob.Sunburst{Ids: ids, Labels: labels, Parents: parents, Values: values,
+		Branchvalues: grob.SunburstBranchvaluesTotal, Type: grob.TraceTypeSunburst}
+	if treemap {
+		tr = &grob.Treemap{Ids: ids, Labels: labels, Parents: parents, Values: values,
+			Branchvalues: grob.TreemapBranchvaluesTotal, Type: grob.TraceTypeTreemap}
+	}
+	fig := &grob.Fig{Data: grob.Traces{tr}}
+
+	return newPlotOpts(opts...).plot(fig, nil, "Observation Count By Stratum", "", "", false,
+		outDir, outFile, imageTypes, show)
+}
+
+// hierarchy returns the hierarchy of the strat. In rollup mode this is Tree, otherwise it is built from the
+// joint strat.
+func (strt *Strat) hierarchy() *Node {
+	if root := strt.Tree(); root != nil {
+		return root
+	}
+
+	root := &Node{Count: 0}
+	nodes := make(map[string]*Node)
+	for ind, key := range strt.keys {
+		parent := root
+		root.Count += strt.count[ind]
+		for d := 1; d <= len(key); d++ {
+			id := keyString(key[:d])
+			node, ok := nodes[id]
+			if !ok {
+				node = &Node{Field: strt.fields[d-1], Value: key[d-1]}
+				nodes[id] = node
+				parent.Children = append(parent.Children, node)
+			}
+			node.Count += strt.count[ind]
+			parent = node
+		}
+	}
+
+	return root
+}
+
+// PlotComparison plots the distribution of the input data and sampleTable across strata side-by-side.
+// The distributions are the percentage of rows in each stratum.
+func (gn *Generator) PlotComparison(outDir, outFile string, imageTypes []utilities.PlotlyImage, show bool,
+	opts ...PlotOpt) error {
+	fig, lay, e := gn.comparisonFig()
+	if e != nil {
+		return e
+	}
+
+	return newPlotOpts(opts...).plot(fig, lay, "Population vs Sample Distribution By Stratum", "Stratum", "Percent",
+		true, outDir, outFile, imageTypes, show)
+}
+
+// comparisonFig returns the figure for PlotComparison.
+func (gn *Generator) comparisonFig() (*grob.Fig, *grob.Layout, error) {
+	if gn.sampleStrats == nil {
+		return nil, nil, fmt.Errorf("(*Generator) PlotComparison: must run MakeTable first")
+	}
+
+	x := make([]string, len(gn.strats.keys))
+	pop, smp := make([]float64, len(x)), make([]float64, len(x))
+	lookup := make(map[string]int)
+	for ind, key := range gn.strats.keys {
+		x[ind] = gn.strats.label(key)
+		pop[ind] = 100.0 * float64(gn.strats.count[ind]) / float64(gn.strats.n)
+		lookup[keyString(key)] = ind
+	}
+
+	for ind, key := range gn.sampleStrats.keys {
+		if row, ok := lookup[keyString(key)]; ok && gn.sampleStrats.n > 0 {
+			smp[row] = 100.0 * float64(gn.sampleStrats.count[ind]) / float64(gn.sampleStrats.n)
+		}
+	}
+
+	fig := &grob.Fig{Data: grob.Traces{
+		&grob.Bar{X: x, Y: pop, Name: "Population", Type: grob.TraceTypeBar},
+		&grob.Bar{X: x, Y: smp, Name: "Sample", Type: grob.TraceTypeBar},
+	}}
+	lay := &grob.Layout{Barmode: grob.BarBarmodeGroup}
+
+	return fig, lay, nil
+}
+
+// PlotRates plots the sampling rates calculated by CalcRates by stratum.
+func (gn *Generator) PlotRates(outDir, outFile string, imageTypes []utilities.PlotlyImage, show bool,
+	opts ...PlotOpt) error {
+	fig, e := gn.ratesFig()
+	if e != nil {
+		return e
+	}
+
+	return newPlotOpts(opts...).plot(fig, nil, "Sampling Rate By Stratum", "Stratum", "Sampling Rate", false,
+		outDir, outFile, imageTypes, show)
+}
+
+// ratesFig returns the figure for PlotRates.
+func (gn *Generator) ratesFig() (*grob.Fig, error) {
+	if gn.strats == nil {
+		return nil, fmt.Errorf("(*Generator) PlotRates: must run CalcRates first")
+	}
+
+	x := make([]string, len(gn.strats.keys))
+	for ind, key := range gn.strats.keys {
+		x[ind] = gn.strats.label(key)
+	}
+
+	tr := &grob.Bar{X: x, Y: gn.sampleRate, Type: grob.TraceTypeBar}
+
+	return &grob.Fig{Data: grob.Traces{tr}}, nil
+}
@@ -0,0 +1,22 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrat_hierarchy(t *testing.T) {
+	strt := &Strat{
+		fields: []string{"state", "purpose"},
+		keys:   [][]any{{"CA", "P"}, {"NY", "P"}, {"CA", "C"}},
+		count:  []uint64{3, 4, 2},
+	}
+
+	root := strt.hierarchy()
+	assert.Equal(t, uint64(9), root.Count)
+	assert.Equal(t, 2, len(root.Children))
+	assert.Equal(t, "CA", root.Children[0].Value)
+	assert.Equal(t, uint64(5), root.Children[0].Count)
+	assert.Equal(t, "purpose", root.Children[0].Children[1].Field)
+}
@@ -0,0 +1,74 @@
+package sampler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ppsCols are the working columns of the PPS query that are not in sampleTable.
+var ppsCols = []string{"ppsDraw", "ppsSize", "ppsTarget", "ppsStratum", "ppsRank", "ppsTail", "ppsCertain",
+	"ppsNCertain", "ppsFree"}
+
+// PPS samples rows within each stratum with probability proportional to size, a numeric column of Query such as the
+// loan balance, rather than uniformly.  The expected sample of a stratum is still its sampling rate times its count.
+//
+// The inclusion probability of a row is c*size, with c chosen for each stratum so that the probabilities sum to the
+// expected sample of the stratum.  Probabilities that would exceed 1 are capped at 1 and the excess is redistributed
+// over the other rows of the stratum.  Sizes that are NULL or negative are taken as 0, so these rows aren't sampled.
+// sampleTable has a column, inclusionProb, with the inclusion probability of each row, for Horvitz-Thompson estimates.
+//
+// PPS sampling can't be used with tiers, class balancing, balancing on a measure, top-ups, MakeDisjoint or Refresh.
+// Calling PPS with an empty size turns off PPS sampling.
+func (gn *Generator) PPS(size string) error {
+	if size != "" && gn.balanceMeasure {
+		return fmt.Errorf("(*Generator) PPS: cannot use PPS sampling when balancing on a measure")
+	}
+
+	if size != "" && (gn.tiers != nil || gn.classLabel != "" || gn.topUpTol > 0.0) {
+		return fmt.Errorf("(*Generator) PPS: cannot use PPS sampling with tiers, class balancing or a top-up")
+	}
+
+	gn.ppsSize = size
+
+	return nil
+}
+
+// ppsQuery returns the query that selects the PPS sample from query, which has the columns of Query.
+//
+// Within each stratum, the rows are ranked by size, descending.  With m rows certain (probability 1), c is
+// (target - m)/(sum of the sizes of the other rows).  Row j (1-based) is certain if it and all the rows before it
+// satisfy size_j > 0 and size_j * (target - j + 1) >= (sum of the sizes of rows j and after).  If every row with a
+// positive size is certain, the other rows have probability 0.
+func (gn *Generator) ppsQuery(query string) string {
+	return fmt.Sprintf("SELECT\n  * EXCEPT (%s)\nFROM (\n%s)\nWHERE ppsDraw < inclusionProb\n",
+		strings.Join(ppsCols, ", "), gn.ppsProbQuery(query))
+}
+
+// ppsProbQuery returns the query that adds inclusionProb and the working columns of ppsQuery to every row of query.
+func (gn *Generator) ppsProbQuery(query string) string {
+	const (
+		part = "PARTITION BY ppsStratum"
+		desc = "PARTITION BY ppsStratum ORDER BY ppsSize DESC"
+	)
+
+	strata := make([]string, len(gn.strats.fields))
+	for ind, f := range gn.strats.fields {
+		strata[ind] = "b." + f
+	}
+
+	sel := fmt.Sprintf("%s,\n  %s AS ppsDraw,\n  greatest(0, ifNull(toFloat64(a.%s), 0)) AS ppsSize,\n  b.sampleRate * b.count AS ppsTarget,\n"+
+		"  cityHash64(%s) AS ppsStratum", gn.sampleCols(), gn.draw("a", 0), gn.ppsSize, strings.Join(strata, ", "))
+	qry := fmt.Sprintf("SELECT\n  %s\nFROM\n  (%s) AS a\nJOIN\n  %s AS b\n ON \n", sel, gn.available(query), gn.stratTable)
+	qry = fmt.Sprintf("%s %s", qry, gn.joins("a", "b"))
+
+	qry = fmt.Sprintf("SELECT\n  *,\n  row_number() OVER (%s) AS ppsRank,\n"+
+		"  sum(ppsSize) OVER (%s ROWS BETWEEN CURRENT ROW AND UNBOUNDED FOLLOWING) AS ppsTail\nFROM (\n%s)", desc, desc, qry)
+	qry = fmt.Sprintf("SELECT\n  *,\n  min(ppsSize > 0 AND ppsSize * (ppsTarget - ppsRank + 1) >= ppsTail) OVER "+
+		"(%s ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW) AS ppsCertain\nFROM (\n%s)", desc, qry)
+	qry = fmt.Sprintf("SELECT\n  *,\n  sum(ppsCertain) OVER (%s) AS ppsNCertain,\n"+
+		"  sum(if(ppsCertain = 1, 0, ppsSize)) OVER (%s) AS ppsFree\nFROM (\n%s)", part, part, qry)
+	qry = fmt.Sprintf("SELECT\n  *,\n  multiIf(ppsCertain = 1, 1.0, ppsFree > 0, least(1.0, (ppsTarget - ppsNCertain) * ppsSize / ppsFree), 0.0) "+
+		"AS inclusionProb\nFROM (\n%s)", qry)
+
+	return qry
+}
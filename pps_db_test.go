@@ -0,0 +1,76 @@
+//go:build db
+
+package sampler
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/invertedv/chutils"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGenerator_ppsProbQuery checks the inclusion probabilities ClickHouse calculates against ppsReference.
+// Run with -tags db; it assumes a DB connection and a tmp database.
+func TestGenerator_ppsProbQuery(t *testing.T) {
+	conn, e := chutils.NewConnect(os.Getenv("host"), os.Getenv("user"), os.Getenv("pw"), nil)
+	assert.Nil(t, e)
+
+	// skewed sizes, with some 0s and NULLs, so that some rows are certain and some can't be drawn
+	rnd := rand.New(rand.NewSource(7))
+	states := []string{"TX", "VT"}
+	sizes := make(map[string][]float64)
+	rows := make([]string, 0)
+	for id := 0; id < 100; id++ {
+		state := states[id%2]
+		size, upb := 0.0, "NULL"
+		switch u := rnd.Float64(); {
+		case u < 0.05:
+		case u < 0.1:
+			upb = "0"
+		default:
+			size = math.Round(1000*math.Exp(2*rnd.NormFloat64())) / 1000
+			upb = fmt.Sprintf("%v", size)
+		}
+		sizes[state] = append(sizes[state], size)
+		rows = append(rows, fmt.Sprintf("(%d, '%s', %s)", id, state, upb))
+	}
+	qry := fmt.Sprintf("SELECT * FROM values('id UInt32, state String, upb Nullable(Float64)', %s)",
+		strings.Join(rows, ", "))
+
+	gn := NewGenerator(qry, "tmp.ppsSample", "tmp.ppsStrats", 30, false, conn)
+	assert.Nil(t, gn.PPS("upb"))
+	assert.Nil(t, gn.CalcRates("state"))
+	assert.Nil(t, gn.Save())
+
+	ref := make(map[string][]float64)
+	for ind, key := range gn.strats.keys {
+		state := key[0].(string)
+		ref[state] = ppsReference(sizes[state], gn.sampleRate[ind]*float64(gn.strats.count[ind]))
+	}
+
+	res, e := conn.Query(fmt.Sprintf("SELECT state, inclusionProb FROM (%s) ORDER BY id", gn.ppsProbQuery(gn.Query)))
+	assert.Nil(t, e)
+
+	got := make(map[string][]float64)
+	for res.Next() {
+		var (
+			state string
+			prob  float64
+		)
+		assert.Nil(t, res.Scan(&state, &prob))
+		got[state] = append(got[state], prob)
+	}
+	assert.Nil(t, res.Err())
+	assert.Nil(t, res.Close())
+
+	for _, state := range states {
+		assert.InDeltaSlice(t, ref[state], got[state], 1e-9)
+	}
+
+	assert.Nil(t, conn.Execute("DROP TABLE IF EXISTS tmp.ppsStrats"))
+}
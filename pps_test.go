@@ -0,0 +1,155 @@
+package sampler
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_ppsQuery(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.strats.fields = []string{"state", "vintage"}
+	assert.Nil(t, gn.PPS("upb"))
+
+	qry := gn.sampleQuery(gn.Query)
+	assert.Contains(t, qry, "greatest(0, ifNull(toFloat64(a.upb), 0)) AS ppsSize")
+	assert.Contains(t, qry, "b.sampleRate * b.count AS ppsTarget")
+	assert.Contains(t, qry, "cityHash64(b.state, b.vintage) AS ppsStratum")
+	assert.Contains(t, qry, "min(ppsSize > 0 AND ppsSize * (ppsTarget - ppsRank + 1) >= ppsTail)")
+	assert.Contains(t, qry, "multiIf(ppsCertain = 1, 1.0, ppsFree > 0, least(1.0, (ppsTarget - ppsNCertain) * ppsSize / ppsFree), 0.0) AS inclusionProb")
+	assert.Contains(t, qry, "* EXCEPT (ppsDraw, ppsSize, ppsTarget, ppsStratum, ppsRank, ppsTail, ppsCertain, ppsNCertain, ppsFree)")
+	assert.Contains(t, qry, "WHERE ppsDraw < inclusionProb")
+
+	assert.Nil(t, gn.PPS(""))
+	assert.NotContains(t, gn.sampleQuery(gn.Query), "inclusionProb")
+	assert.Nil(t, gn.PPS("upb"))
+
+	// PPS doesn't mix with tiers or a balanced measure
+	assert.Nil(t, gn.Tiers(100, 1000))
+	assert.NotNil(t, gn.CalcRates("state"))
+	assert.Nil(t, gn.Tiers())
+
+	gn.Measure("sum(upb)", true)
+	assert.NotNil(t, gn.CalcRates("state"))
+	assert.NotNil(t, gn.PPS("upb"))
+	gn = NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+
+	// PPS itself rejects tiers, class balancing and a top-up
+	assert.Nil(t, gn.Tiers(100, 1000))
+	assert.NotNil(t, gn.PPS("upb"))
+	assert.Nil(t, gn.Tiers())
+
+	assert.Nil(t, gn.BalanceClasses("default"))
+	assert.NotNil(t, gn.PPS("upb"))
+	assert.Nil(t, gn.BalanceClasses(""))
+
+	assert.Nil(t, gn.TopUp(0.05, "loanId"))
+	assert.NotNil(t, gn.PPS("upb"))
+	assert.Nil(t, gn.TopUp(0))
+	assert.Nil(t, gn.PPS("upb"))
+}
+
+// ppsProbs calculates the inclusion probabilities of sizes as ppsQuery does.
+func ppsProbs(sizes []float64, target float64) []float64 {
+	ord := make([]int, len(sizes))
+	for ind := range ord {
+		ord[ind] = ind
+	}
+	sort.SliceStable(ord, func(i, j int) bool { return sizes[ord[i]] > sizes[ord[j]] })
+
+	certain := make([]bool, len(sizes))
+	tail, nCertain, free := sum(sizes), 0.0, 0.0
+	prev := true
+	for rank, ind := range ord {
+		certain[ind] = prev && sizes[ind] > 0 && sizes[ind]*(target-float64(rank+1)+1) >= tail
+		prev = certain[ind]
+		tail -= sizes[ind]
+
+		if certain[ind] {
+			nCertain++
+		} else {
+			free += sizes[ind]
+		}
+	}
+
+	probs := make([]float64, len(sizes))
+	for ind, sz := range sizes {
+		switch {
+		case certain[ind]:
+			probs[ind] = 1.0
+		case free > 0:
+			probs[ind] = math.Min(1.0, (target-nCertain)*sz/free)
+		}
+	}
+
+	return probs
+}
+
+// ppsReference calculates the inclusion probabilities of sizes by capping c*size at 1 and spreading the excess over
+// the rows that aren't capped until no more rows are capped.
+func ppsReference(sizes []float64, target float64) []float64 {
+	probs := make([]float64, len(sizes))
+	capped := make([]bool, len(sizes))
+	for {
+		nCapped, free := 0.0, 0.0
+		for ind, sz := range sizes {
+			if capped[ind] {
+				nCapped++
+				continue
+			}
+			free += sz
+		}
+
+		more := false
+		for ind, sz := range sizes {
+			if capped[ind] {
+				probs[ind] = 1.0
+				continue
+			}
+
+			probs[ind] = 0.0
+			if free > 0 {
+				probs[ind] = (target - nCapped) * sz / free
+			}
+
+			if probs[ind] >= 1.0 {
+				capped[ind], more = true, true
+			}
+		}
+
+		if !more {
+			return probs
+		}
+	}
+}
+
+func TestPPSProbs(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	for trial := 0; trial < 200; trial++ {
+		n := 1 + rnd.Intn(50)
+		sizes := make([]float64, n)
+		positive := 0
+		for ind := range sizes {
+			// skewed sizes, with some 0s, so that some rows are certain
+			if rnd.Float64() < 0.1 {
+				continue
+			}
+			sizes[ind] = math.Exp(3 * rnd.NormFloat64())
+			positive++
+		}
+		target := rnd.Float64() * float64(n)
+
+		probs, ref := ppsProbs(sizes, target), ppsReference(sizes, target)
+		assert.InDeltaSlice(t, ref, probs, 1e-9)
+
+		// the probabilities sum to the target, if there are enough rows of positive size
+		assert.InDelta(t, math.Min(target, float64(positive)), sum(probs), 1e-6)
+	}
+
+	// every row of positive size is certain, so ppsFree is 0
+	assert.Equal(t, []float64{1, 1, 0}, ppsProbs([]float64{5, 2, 0}, 2.5))
+}
@@ -0,0 +1,307 @@
+package sampler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// Refresh updates sampleTable for the new data selected by newDataQuery, which must have the same columns as Query.
+// Query becomes the union of Query and newDataQuery.  The strats of newDataQuery are added to the strat counts, so the
+// data already stratified isn't read again, and the sampling rates are recalculated using the same strat fields.  The
+// strat fields keep the values they were resolved to (e.g. by WithTopN), so the strata don't change.  The measure, if
+// any, must be additive, such as a sum.  With CollapseNone, strata dropped for having fewer than minCount rows are
+// kept aside and come back once they have minCount rows.  Strats with summaries or made in rollup mode, PPS samples,
+// tiered samples and class-balanced samples can't be refreshed.
+//
+// Rows of the previous sample are kept as far as the new sampling rates allow:
+//   - with HashSample, rows are kept if they would be sampled at the new rate and the rows of strata whose rate has
+//     risen that would now be sampled are added, so the sample is the one MakeTable would build;
+//   - otherwise, rows are kept with probability newRate/oldRate.  Strata whose rate has risen keep all their rows but
+//     their previous data stays sampled at the old rate, so these strata are under-sampled by the share of
+//     their rows that came before the refresh.  Run MakeTable to rebuild the sample if that matters.
+//
+// Rows of newDataQuery are sampled at the new rates and appended.  The new sample and strats are built in temporary
+// tables that are swapped with sampleTable and stratTable at the end, so the Generator and its tables are unchanged
+// if Refresh fails.  MakeTable must be run first.
+// timeOut is the query time out in minutes.
+func (gn *Generator) Refresh(newDataQuery string, timeOut int64) error {
+	if gn.sampleStrats == nil {
+		return fmt.Errorf("(*Generator) Refresh: must run MakeTable first")
+	}
+
+	if gn.ppsSize != "" {
+		return fmt.Errorf("(*Generator) Refresh: cannot refresh PPS samples")
+	}
+
+	if gn.tiers != nil || gn.classLabel != "" {
+		return fmt.Errorf("(*Generator) Refresh: cannot refresh tiered or class-balanced samples")
+	}
+
+	if len(gn.strats.summaries) > 0 || gn.strats.rollup {
+		return fmt.Errorf("(*Generator) Refresh: cannot refresh strats with summaries or in rollup mode")
+	}
+
+	// nw is the refreshed Generator.  Its stratTable is temporary until the swap.
+	nw := *gn
+	nw.stratTable, nw.partitions = gn.stratTable+"_refresh", append([]string{}, gn.partitions...)
+	strats := *gn.strats
+	nw.strats = &strats
+	if gn.excluded != nil {
+		excluded := *gn.excluded
+		nw.excluded = &excluded
+	}
+
+	if e := nw.addPartition(newDataQuery); e != nil {
+		return e
+	}
+
+	if e := nw.allocateRates(); e != nil {
+		return e
+	}
+
+	if e := nw.Save(); e != nil {
+		return e
+	}
+
+	chutils.WithTimeOut(timeOut)(gn.conn)
+
+	// the current stratTable has the rates the current sample was drawn at
+	tmpTable := gn.sampleTable + "_refresh"
+	sels := nw.refreshQueries(gn.Query, newDataQuery, gn.stratTable)
+	qrys := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", tmpTable),
+		fmt.Sprintf("CREATE TABLE %s AS %s", tmpTable, gn.sampleTable),
+	}
+
+	for _, sel := range sels {
+		qrys = append(qrys, fmt.Sprintf("INSERT INTO %s %s", tmpTable, sel))
+	}
+
+	if e := gn.execute(qrys...); e != nil {
+		return e
+	}
+
+	if e := gn.execute(fmt.Sprintf("EXCHANGE TABLES %s AND %s", tmpTable, gn.sampleTable)); e != nil {
+		return e
+	}
+
+	if e := gn.execute(fmt.Sprintf("EXCHANGE TABLES %s AND %s", nw.stratTable, gn.stratTable),
+		fmt.Sprintf("DROP TABLE %s", tmpTable),
+		fmt.Sprintf("DROP TABLE %s", nw.stratTable)); e != nil {
+		return e
+	}
+
+	nw.stratTable, nw.makeQuery = gn.stratTable, strings.Join(sels, "\nUNION ALL\n")
+	*gn = nw
+
+	if e := gn.makeSampleStrats(); e != nil {
+		return e
+	}
+
+	if gn.auditTable != "" {
+		return gn.audit()
+	}
+
+	return nil
+}
+
+// addPartition makes Query the union of Query and newDataQuery and adds the strats of newDataQuery to gn.strats and,
+// if there's an exclusion, to gn.excluded.  gn.strats and gn.excluded are replaced, not changed.
+func (gn *Generator) addPartition(newDataQuery string) error {
+	part, e := gn.partStrat(gn.available(newDataQuery), gn.strats.measure)
+	if e != nil {
+		return e
+	}
+
+	// the union stays flat however many times the data is refreshed
+	if gn.partitions == nil || union(gn.partitions) != gn.Query {
+		gn.partitions = []string{gn.Query}
+	}
+	gn.partitions = append(gn.partitions, newDataQuery)
+	gn.Query = union(gn.partitions)
+
+	gn.strats.add(part)
+	gn.strats.Query = gn.available(gn.Query)
+
+	if gn.excluded == nil {
+		return nil
+	}
+
+	excl, e := gn.partStrat(fmt.Sprintf("SELECT * FROM (%s) WHERE %s", newDataQuery, gn.exclusion("IN")), "")
+	if e != nil {
+		return e
+	}
+
+	// gn.excluded is expressed in the strata of gn.strats, which may have changed
+	gn.excluded.add(excl)
+	gn.excluded.collapseLike(gn.strats)
+	gn.excluded.Query = gn.excludedQuery()
+
+	return nil
+}
+
+// partStrat returns the strats of query made with the fields and specs of gn.strats and with measure.  The strata
+// aren't collapsed.
+func (gn *Generator) partStrat(query, measure string) (*Strat, error) {
+	part := NewStrat(query, gn.conn, false)
+	part.specs = copySpecs(gn.strats.specs)
+	part.Measure(measure)
+
+	if e := part.Make(gn.strats.fields...); e != nil {
+		return nil, e
+	}
+
+	return part, nil
+}
+
+// union returns the query that selects the rows of each of queries.
+func union(queries []string) string {
+	sels := make([]string, len(queries))
+	for ind, qry := range queries {
+		sels[ind] = fmt.Sprintf("SELECT * FROM (%s)", qry)
+	}
+
+	return strings.Join(sels, "\nUNION ALL\n")
+}
+
+// add adds the strats of part, which has the fields and specs of strt, to the strata of strt as found in the data,
+// including those dropped under CollapseNone, and collapses them again.  The measure is added, too.
+func (strt *Strat) add(part *Strat) {
+	keys := append(append([][]any{}, strt.rawKeys...), strt.dropKeys...)
+	counts := append(append([]uint64{}, strt.rawCount...), strt.dropCount...)
+	var measures []float64
+	if strt.measure != "" {
+		measures = append(append([]float64{}, strt.rawMeasures...), strt.dropMeasures...)
+	}
+
+	index := make(map[string]int)
+	for ind, key := range keys {
+		index[keyString(key)] = ind
+	}
+
+	for ind, key := range part.keys {
+		row, ok := index[keyString(key)]
+		if !ok {
+			row = len(keys)
+			index[keyString(key)] = row
+			keys, counts = append(keys, key), append(counts, 0)
+			if measures != nil {
+				measures = append(measures, 0.0)
+			}
+		}
+
+		counts[row] += part.count[ind]
+		if measures != nil {
+			measures[row] += part.measures[ind]
+		}
+	}
+
+	// order the strata as Make does
+	order := make([]int, 0, len(keys))
+	strt.dropKeys, strt.dropCount, strt.dropMeasures = nil, nil, nil
+	for ind, c := range counts {
+		if strt.minCount > 0 && strt.collapse == CollapseNone && c < uint64(strt.minCount) {
+			strt.dropKeys, strt.dropCount = append(strt.dropKeys, keys[ind]), append(strt.dropCount, c)
+			if measures != nil {
+				strt.dropMeasures = append(strt.dropMeasures, measures[ind])
+			}
+			continue
+		}
+		order = append(order, ind)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		if strt.sortByCounts {
+			return counts[order[i]] > counts[order[j]]
+		}
+
+		return lessKey(keys[order[i]], keys[order[j]])
+	})
+
+	strt.keys, strt.count, strt.measures, strt.n = make([][]any, len(order)), make([]uint64, len(order)), nil, 0
+	strt.group = make([]int, len(order))
+	for ind, o := range order {
+		strt.keys[ind], strt.count[ind], strt.group[ind] = keys[o], counts[o], ind
+		strt.n += counts[o]
+		if measures != nil {
+			strt.measures = append(strt.measures, measures[o])
+		}
+	}
+
+	strt.rawKeys, strt.rawCount, strt.rawMeasures = strt.keys, strt.count, strt.measures
+
+	if strt.minCount > 0 && strt.collapse != CollapseNone {
+		strt.collapseSparse()
+	}
+}
+
+// lessKey returns true if key a sorts before key b.
+func lessKey(a, b []any) bool {
+	for col := range a {
+		if sameValue(a[col], b[col]) {
+			continue
+		}
+
+		ta, okA := a[col].(time.Time)
+		tb, okB := b[col].(time.Time)
+		if okA && okB {
+			return ta.Before(tb)
+		}
+
+		fa, okA := toFloat(a[col])
+		fb, okB := toFloat(b[col])
+		if okA && okB {
+			return fa < fb
+		}
+
+		return format(a[col]) < format(b[col])
+	}
+
+	return false
+}
+
+// refreshQueries returns the queries whose rows make up the refreshed sample: the query that trims the current sample
+// to the new rates, with HashSample the query that draws the rows of oldQuery that are sampled at the new rates but
+// weren't at the previous rates, and the query that samples the new data.  prevTable has the rates the current sample
+// was drawn at.
+func (gn *Generator) refreshQueries(oldQuery, newDataQuery, prevTable string) []string {
+	keep := fmt.Sprintf("%s < b.sampleRate / p.sampleRate", gn.draw("s", 2))
+	if gn.hashKey != nil {
+		keep = fmt.Sprintf("%s < b.sampleRate", gn.draw("s", 0))
+	}
+
+	// rows that have since been excluded are dropped
+	src := gn.sampleTable
+	if gn.excludeSource != "" {
+		src = fmt.Sprintf("(%s)", gn.available("SELECT * FROM "+gn.sampleTable))
+	}
+
+	trim := fmt.Sprintf("SELECT\n  s.*\nFROM\n  %s AS s\nJOIN\n  %s AS b\n ON \n %sJOIN\n  %s AS p\n ON \n %s WHERE %s\n",
+		src, gn.stratTable, gn.joins("s", "b"), prevTable, gn.joins("s", "p"), keep)
+
+	if gn.hashKey == nil {
+		return []string{trim, gn.sampleQuery(newDataQuery)}
+	}
+
+	// strata not in prevTable had no rows sampled, so their previous rate is 0
+	u := gn.draw("a", 0)
+	redraw := fmt.Sprintf("SELECT\n  %s\nFROM\n  (%s) AS a\nJOIN\n  %s AS b\n ON \n %sLEFT JOIN\n  %s AS p\n ON \n %s WHERE %s >= p.sampleRate AND %s < b.sampleRate\n",
+		gn.sampleCols(), gn.available(oldQuery), gn.stratTable, gn.joins("a", "b"), prevTable, gn.joins("a", "p"), u, u)
+
+	return []string{trim, redraw, gn.sampleQuery(newDataQuery)}
+}
+
+// execute runs qrys in order.
+func (gn *Generator) execute(qrys ...string) error {
+	for _, qry := range qrys {
+		if e := gn.conn.Execute(qry); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_refreshQueries(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.strats.fields = []string{"state"}
+
+	sels := gn.refreshQueries(gn.Query, "SELECT * FROM newSrc", "strt_prev")
+	assert.Equal(t, 2, len(sels))
+	assert.Contains(t, sels[0], "smp AS s")
+	assert.Contains(t, sels[0], "s.state = b.state")
+	assert.Contains(t, sels[0], "s.state = p.state")
+	assert.Contains(t, sels[0], "rand32(rowNumberInAllBlocks() + 2) / 4294967295.0 < b.sampleRate / p.sampleRate")
+	assert.Contains(t, sels[1], "(SELECT * FROM newSrc) AS a")
+
+	// with hash sampling, rows are kept if they'd be sampled at the new rate and the old data is drawn again for
+	// the rows the new rate adds
+	gn.HashSample("v1", "loanId")
+	sels = gn.refreshQueries(gn.Query, "SELECT * FROM newSrc", "strt_prev")
+	assert.Equal(t, 3, len(sels))
+	assert.Contains(t, sels[0], "cityHash64(s.loanId, 'v1') / 18446744073709551615.0 < b.sampleRate\n")
+	assert.Contains(t, sels[1], "(SELECT * FROM src) AS a")
+	assert.Contains(t, sels[1], "LEFT JOIN\n  strt_prev AS p")
+	assert.Contains(t, sels[1], "WHERE cityHash64(a.loanId, 'v1') / 18446744073709551615.0 >= p.sampleRate AND "+
+		"cityHash64(a.loanId, 'v1') / 18446744073709551615.0 < b.sampleRate\n")
+}
+
+func TestStrat_add(t *testing.T) {
+	strt := &Strat{fields: []string{"state"}, minCount: 100, collapse: CollapseOther, measure: "sum(upb)"}
+	strt.rawKeys, strt.rawCount, strt.rawMeasures = [][]any{{"CA"}, {"NY"}, {"WY"}}, []uint64{500, 200, 20}, []float64{5, 2, 1}
+	strt.collapseSparse()
+	assert.Equal(t, [][]any{{"CA"}, {"NY"}, {Other}}, strt.keys)
+
+	part := &Strat{fields: []string{"state"}}
+	part.keys, part.count, part.measures = [][]any{{"AK"}, {"WY"}}, []uint64{50, 90}, []float64{3, 4}
+	strt.add(part)
+
+	// WY now has enough rows and AK is new
+	assert.Equal(t, [][]any{{"AK"}, {"CA"}, {"NY"}, {"WY"}}, strt.rawKeys)
+	assert.Equal(t, []uint64{50, 500, 200, 110}, strt.rawCount)
+	assert.Equal(t, [][]any{{"CA"}, {"NY"}, {"WY"}, {Other}}, strt.keys)
+	assert.Equal(t, []uint64{500, 200, 110, 50}, strt.count)
+	assert.Equal(t, []float64{5, 2, 5, 3}, strt.measures)
+	assert.Equal(t, []int{3, 0, 1, 2}, strt.group)
+	assert.Equal(t, uint64(860), strt.n)
+}
+
+func TestStrat_addDropped(t *testing.T) {
+	strt := &Strat{fields: []string{"state"}, minCount: 100, collapse: CollapseNone, measure: "sum(upb)",
+		summaries: []*Summary{{Column: "fico", Stat: StatMean}}}
+	strt.keys, strt.count, strt.measures = [][]any{{"CA"}, {"NY"}, {"WY"}}, []uint64{500, 200, 60}, []float64{5, 2, 1}
+	strt.summaries[0].Values = []float64{700, 710, 720}
+	strt.dropSparse()
+
+	// WY is dropped but kept aside
+	assert.Equal(t, [][]any{{"CA"}, {"NY"}}, strt.keys)
+	assert.Equal(t, uint64(700), strt.n)
+	assert.Equal(t, []float64{700, 710}, strt.summaries[0].Values)
+	assert.Equal(t, [][]any{{"WY"}}, strt.dropKeys)
+	assert.Equal(t, []uint64{60}, strt.dropCount)
+	strt.rawKeys, strt.rawCount, strt.rawMeasures = strt.keys, strt.count, strt.measures
+
+	part := &Strat{fields: []string{"state"}}
+	part.keys, part.count, part.measures = [][]any{{"AK"}, {"WY"}}, []uint64{50, 40}, []float64{3, 4}
+	strt.add(part)
+
+	// WY has 100 rows counting the ones it had before, AK is still too small
+	assert.Equal(t, [][]any{{"CA"}, {"NY"}, {"WY"}}, strt.keys)
+	assert.Equal(t, []uint64{500, 200, 100}, strt.count)
+	assert.Equal(t, []float64{5, 2, 5}, strt.measures)
+	assert.Equal(t, [][]any{{"AK"}}, strt.dropKeys)
+	assert.Equal(t, []uint64{50}, strt.dropCount)
+	assert.Equal(t, []float64{3}, strt.dropMeasures)
+	assert.Equal(t, uint64(800), strt.n)
+}
+
+func TestGenerator_RefreshRejects(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.sampleStrats = NewStrat(gn.Query, nil, false)
+
+	gn.tiers = []int{100, 1000}
+	assert.NotNil(t, gn.Refresh("SELECT * FROM newSrc", 0))
+
+	gn.tiers, gn.classLabel = nil, "default"
+	assert.NotNil(t, gn.Refresh("SELECT * FROM newSrc", 0))
+	assert.Equal(t, "SELECT * FROM src", gn.Query)
+}
+
+func TestUnion(t *testing.T) {
+	qry := union([]string{"SELECT * FROM a", "SELECT * FROM b", "SELECT * FROM c"})
+	assert.Equal(t, "SELECT * FROM (SELECT * FROM a)\nUNION ALL\nSELECT * FROM (SELECT * FROM b)\n"+
+		"UNION ALL\nSELECT * FROM (SELECT * FROM c)", qry)
+}
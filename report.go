@@ -0,0 +1,240 @@
+package sampler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+	"github.com/dustin/go-humanize"
+)
+
+// plotlyJS is the plotly.js script the report loads its charts with.
+const plotlyJS = "https://cdn.plot.ly/plotly-2.18.2.min.js"
+
+// reportTable is a table in the report.
+type reportTable struct {
+	Title string
+	Heads []string
+	Rows  [][]string
+}
+
+// reportChart is a chart in the report. Fig is the plotly figure as JSON.
+type reportChart struct {
+	ID  string
+	Fig template.JS
+}
+
+// reportData is the data the report template is executed with.
+type reportData struct {
+	Created  string
+	PlotlyJS string
+	Params   [][2]string
+	Tables   []*reportTable
+	Charts   []*reportChart
+	Query    string
+	MakeQry  string
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sampling Report</title>
+<script src="{{.PlotlyJS}}"></script>
+<style>
+body {font-family: sans-serif; margin: 2em;}
+table {border-collapse: collapse; margin-bottom: 2em;}
+th, td {border: 1px solid #ccc; padding: 0.25em 0.75em; text-align: right;}
+th {background: #eee;}
+pre {background: #f6f6f6; padding: 1em; overflow-x: auto;}
+</style>
+</head>
+<body>
+<h1>Sampling Report</h1>
+<p>Created {{.Created}}</p>
+<h2>Parameters</h2>
+<table>
+{{range .Params}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+{{range .Tables}}<h2>{{.Title}}</h2>
+<table>
+<tr>{{range .Heads}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+<h2>Charts</h2>
+{{range .Charts}}<div id="{{.ID}}"></div>
+<script>
+(function() { var fig = {{.Fig}}; Plotly.newPlot("{{.ID}}", fig.data, fig.layout); })();
+</script>
+{{end}}
+<h2>Input Query</h2>
+<pre>{{.Query}}</pre>
+<h2>Sampling Query</h2>
+<pre>{{.MakeQry}}</pre>
+</body>
+</html>
+`))
+
+// Report writes an HTML report of the sampling run to path.  The report has the parameters of the run, the strats
+// of the input data and sampleTable, the marginals of sampleTable, the expected vs actual counts by stratum,
+// charts and the query used to create sampleTable.  The charts are drawn with plotly.js, which is loaded from the
+// plotly CDN.
+func (gn *Generator) Report(path string) error {
+	if gn.sampleStrats == nil {
+		return fmt.Errorf("(*Generator) Report: must run MakeTable first")
+	}
+
+	margs, _, e := gn.Marginals()
+	if e != nil {
+		return e
+	}
+
+	return gn.writeReport(path, margs)
+}
+
+// writeReport writes the report to path given the marginals of sampleTable.
+func (gn *Generator) writeReport(path string, margs []*Strat) error {
+	data := &reportData{
+		Created:  time.Now().Format("2006-01-02 15:04:05"),
+		PlotlyJS: plotlyJS,
+		Params:   gn.params(),
+		Query:    gn.Query,
+		MakeQry:  gn.makeQuery,
+	}
+
+	heads, rows := gn.strats.rows()
+	data.Tables = append(data.Tables, &reportTable{Title: "Input Strats", Heads: heads, Rows: rows})
+	heads, rows = gn.sampleStrats.rows()
+	data.Tables = append(data.Tables, &reportTable{Title: "Sample Strats", Heads: heads, Rows: rows})
+	data.Tables = append(data.Tables, gn.expVsAct())
+
+	if gn.excluded != nil {
+		data.Tables = append(data.Tables, gn.exclusionTable())
+	}
+
+	for ind, marg := range margs {
+		heads, rows = marg.rows()
+		data.Tables = append(data.Tables,
+			&reportTable{Title: fmt.Sprintf("Sample Marginal Distribution of %s", gn.strats.fields[ind]), Heads: heads, Rows: rows})
+	}
+
+	// charts
+	figs := []*grob.Fig{gn.strats.barFig(), gn.sampleStrats.barFig()}
+	titles := []string{"Input Observation Count By Stratum", "Sample Observation Count By Stratum"}
+
+	fig, lay, e := gn.comparisonFig()
+	if e != nil {
+		return e
+	}
+	fig.Layout = lay
+	figs, titles = append(figs, fig), append(titles, "Population vs Sample Distribution By Stratum (Percent)")
+
+	if fig, e = gn.ratesFig(); e != nil {
+		return e
+	}
+	figs, titles = append(figs, fig), append(titles, "Sampling Rate By Stratum")
+
+	for ind, fig := range figs {
+		if fig.Layout == nil {
+			fig.Layout = &grob.Layout{}
+		}
+		fig.Layout.Title = &grob.LayoutTitle{Text: titles[ind]}
+
+		js, e := json.Marshal(fig)
+		if e != nil {
+			return e
+		}
+
+		// the figure is JSON we built, so it's safe to embed
+		data.Charts = append(data.Charts, &reportChart{ID: fmt.Sprintf("chart%d", ind), Fig: template.JS(js)}) // #nosec G203
+	}
+
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+
+	if e := reportTemplate.Execute(f, data); e != nil {
+		_ = f.Close()
+		return e
+	}
+
+	return f.Close()
+}
+
+// params returns the parameters of the sampling run as name/value pairs.
+func (gn *Generator) params() [][2]string {
+	target := "Target # Obs"
+	if gn.balanceMeasure {
+		target = fmt.Sprintf("Target %s", gn.measure)
+	}
+
+	params := [][2]string{
+		{"Strats Table", gn.stratTable},
+		{"Sample Table", gn.sampleTable},
+		{"Strat Fields", fmt.Sprintf("%v", gn.strats.fields)},
+		{target, humanize.Comma(int64(gn.targetTotal))},
+		{"Min Count", humanize.Comma(int64(gn.minCount))},
+		{"Collapse", gn.collapse.String()},
+		{"Sampling Cap", fmt.Sprintf("%0.2f", gn.sampleCap)},
+		{"Expected # Obs", humanize.Comma(int64(gn.expCaptured))},
+		{"Actual # Obs", humanize.Comma(int64(gn.actCaptured))},
+	}
+
+	if gn.measure != "" {
+		params = append(params, [2]string{"Measure", gn.measure})
+	}
+
+	if gn.ppsSize != "" {
+		params = append(params, [2]string{"PPS Size", gn.ppsSize})
+	}
+
+	if gn.classLabel != "" {
+		params = append(params, [2]string{"Class Label", gn.classLabel},
+			[2]string{"Class Ratio", fmt.Sprintf("%v", gn.classRatio)})
+	}
+
+	if gn.topUpTol > 0.0 {
+		params = append(params, [2]string{"Top-Up Tolerance", fmt.Sprintf("%0.2f", gn.topUpTol)},
+			[2]string{"Top-Up # Obs", humanize.Comma(int64(gn.topUpObs))})
+	}
+
+	if gn.excludeSource != "" {
+		params = append(params, [2]string{"Exclusion Source", gn.excludeSource},
+			[2]string{"Exclusion Keys", fmt.Sprintf("%v", gn.excludeKeys)})
+	}
+
+	return params
+}
+
+// expVsAct returns the table of expected vs actual counts by stratum.
+func (gn *Generator) expVsAct() *reportTable {
+	tbl := &reportTable{
+		Title: "Expected vs Actual Sample Counts",
+		Heads: []string{"Stratum", "Input Count", "Sampling Rate", "Expected", "Actual", "Actual - Expected"},
+	}
+
+	act := make(map[string]uint64)
+	for ind, key := range gn.sampleStrats.keys {
+		act[keyString(key)] = gn.sampleStrats.count[ind]
+	}
+
+	for ind, key := range gn.strats.keys {
+		exp := int64(gn.sampleRate[ind] * float64(gn.strats.count[ind]))
+		a := int64(act[keyString(key)])
+		tbl.Rows = append(tbl.Rows, []string{
+			gn.strats.label(key),
+			humanize.Comma(int64(gn.strats.count[ind])),
+			fmt.Sprintf("%0.4f", gn.sampleRate[ind]),
+			humanize.Comma(exp),
+			humanize.Comma(a),
+			humanize.Comma(a - exp),
+		})
+	}
+
+	return tbl
+}
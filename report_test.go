@@ -0,0 +1,43 @@
+package sampler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_writeReport(t *testing.T) {
+	strt := &Strat{
+		fields: []string{"state"},
+		keys:   [][]any{{"CA"}, {"NY"}},
+		count:  []uint64{300, 100},
+		n:      400,
+	}
+	smp := &Strat{
+		fields: []string{"state"},
+		keys:   [][]any{{"CA"}, {"NY"}},
+		count:  []uint64{30, 40},
+		n:      70,
+	}
+
+	gn := NewGenerator("SELECT * FROM input", "sample", "strats", 200, false, nil)
+	gn.strats, gn.sampleStrats, gn.sampleRate = strt, smp, []float64{0.1, 0.4}
+
+	path := filepath.Join(t.TempDir(), "report.html")
+	assert.Nil(t, gn.writeReport(path, []*Strat{smp}))
+
+	b, e := os.ReadFile(path)
+	assert.Nil(t, e)
+	html := string(b)
+
+	assert.Contains(t, html, `<script src="https://cdn.plot.ly/plotly-2.18.2.min.js"></script>`)
+	for _, title := range []string{"Input Strats", "Sample Strats", "Expected vs Actual Sample Counts",
+		"Sample Marginal Distribution of state"} {
+		assert.Contains(t, html, "<h2>"+title+"</h2>")
+	}
+	assert.Contains(t, html, "<tr><td>NY</td><td>100</td><td>0.4000</td><td>40</td><td>40</td><td>0</td></tr>")
+	assert.Contains(t, html, `Plotly.newPlot("chart3"`)
+	assert.Contains(t, html, "SELECT * FROM input")
+}
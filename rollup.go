@@ -0,0 +1,238 @@
+package sampler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// subtotal is a row of a grouping set other than the joint strat.
+type subtotal struct {
+	present []bool // present[i] is true if fields[i] is part of the grouping set
+	key     []any  // values of the fields. Elements for fields not present are meaningless.
+	count   uint64
+	measure float64
+}
+
+// Node is a node of the hierarchy of strats produced by Make in rollup mode.  The root is the grand total. The
+// children of a node at depth d are the values of the (d+1)-th strat field within the node.
+type Node struct {
+	Field    string  // Field is the strat field of this level; empty for the root
+	Value    any     // Value is the value of Field
+	Count    uint64  // Count is the number of rows in the node
+	Measure  float64 // Measure is the value of the measure, if there is one
+	Children []*Node // Children are the nodes at the next level
+}
+
+// Rollup sets rollup mode.  In rollup mode, Make uses a single GROUPING SETS query to produce the joint strat,
+// the subtotals along the hierarchy of the strat fields, the marginal strat of each field and the grand total.
+// Rollup mode requires ClickHouse 22.9 or later.
+func (strt *Strat) Rollup(rollup bool) {
+	strt.rollup = rollup
+}
+
+// Rollup sets rollup mode for the strats of the input data and sampleTable.  In rollup mode, the subtotals and
+// marginals are calculated by the same query as the strats, so Marginals doesn't need a query for each field.
+func (gn *Generator) Rollup(rollup bool) {
+	gn.rollup = rollup
+	gn.reset()
+}
+
+// Total returns the grand total number of rows.  This is 0 if the strats were not made in rollup mode.
+func (strt *Strat) Total() uint64 {
+	for _, st := range strt.subtotals {
+		if st.depth() == 0 {
+			return st.count
+		}
+	}
+
+	return 0
+}
+
+// Tree returns the hierarchy of strats.  The hierarchy follows the order of the strat fields. It returns nil
+// if the strats were not made in rollup mode.
+func (strt *Strat) Tree() *Node {
+	if !strt.rollup || strt.subtotals == nil {
+		return nil
+	}
+
+	root := &Node{Count: strt.Total()}
+	nodes := map[string]*Node{"": root}
+
+	// rows at each depth of the hierarchy, the joint strat being the deepest
+	levels := make([][]*subtotal, len(strt.fields)+1)
+	for _, st := range strt.subtotals {
+		if d := st.depth(); d >= 0 {
+			levels[d] = append(levels[d], st)
+		}
+	}
+
+	all := make([]bool, len(strt.fields))
+	for ind := 0; ind < len(all); ind++ {
+		all[ind] = true
+	}
+
+	for ind, key := range strt.rawKeys {
+		st := &subtotal{present: all, key: key, count: strt.rawCount[ind]}
+		if strt.rawMeasures != nil {
+			st.measure = strt.rawMeasures[ind]
+		}
+		levels[len(strt.fields)] = append(levels[len(strt.fields)], st)
+	}
+
+	for d := 1; d <= len(strt.fields); d++ {
+		for _, st := range levels[d] {
+			parent, ok := nodes[keyString(st.key[:d-1])]
+			if !ok {
+				continue
+			}
+
+			node := &Node{Field: strt.fields[d-1], Value: st.key[d-1], Count: st.count, Measure: st.measure}
+			parent.Children = append(parent.Children, node)
+			nodes[keyString(st.key[:d])] = node
+		}
+	}
+
+	return root
+}
+
+// Marginals returns the marginal strat of each strat field.  It returns nil if the strats were not made in rollup mode.
+func (strt *Strat) Marginals() []*Strat {
+	if !strt.rollup || strt.subtotals == nil {
+		return nil
+	}
+
+	margs := make([]*Strat, len(strt.fields))
+	for col, fld := range strt.fields {
+		marg := &Strat{
+			fields:       []string{fld},
+			Query:        strt.Query,
+			sortByCounts: strt.sortByCounts,
+			conn:         strt.conn,
+			specs:        copySpecs(strt.specs),
+			measure:      strt.measure,
+		}
+
+		if col < len(strt.types) {
+			marg.types = strt.types[col : col+1]
+		}
+
+		// with one field, the marginal is the joint strat
+		if len(strt.fields) == 1 {
+			marg.keys, marg.count, marg.measures, marg.n = strt.rawKeys, strt.rawCount, strt.rawMeasures, strt.n
+		}
+
+		for _, st := range strt.subtotals {
+			if !st.only(col) {
+				continue
+			}
+
+			marg.keys = append(marg.keys, []any{st.key[col]})
+			marg.count = append(marg.count, st.count)
+			if strt.measure != "" {
+				marg.measures = append(marg.measures, st.measure)
+			}
+			marg.n += st.count
+		}
+
+		marg.rawKeys, marg.rawCount, marg.rawMeasures = marg.keys, marg.count, marg.measures
+		margs[col] = marg
+	}
+
+	return margs
+}
+
+// groupingSets returns the GROUPING SETS clause for fields.  The sets are the prefixes of fields, each field
+// individually and the grand total.
+func groupingSets(fields []string) string {
+	sets := make([]string, 0)
+	have := make(map[string]bool)
+	add := func(set string) {
+		if !have[set] {
+			sets = append(sets, set)
+			have[set] = true
+		}
+	}
+
+	for ind := len(fields); ind > 0; ind-- {
+		add(fmt.Sprintf("(%s)", strings.Join(fields[:ind], ",")))
+	}
+
+	for _, fld := range fields {
+		add(fmt.Sprintf("(%s)", fld))
+	}
+
+	add("()")
+
+	return fmt.Sprintf("GROUPING SETS (%s)", strings.Join(sets, ", "))
+}
+
+// groupingCalcs returns the columns that indicate which fields are aggregated over for each row.
+func groupingCalcs(fields []string) (calcs, total string) {
+	cols := make([]string, len(fields))
+	names := make([]string, len(fields))
+	for ind, fld := range fields {
+		names[ind] = fmt.Sprintf("g_%d", ind)
+		cols[ind] = fmt.Sprintf("grouping(%s) AS %s", fld, names[ind])
+	}
+
+	return strings.Join(cols, ", "), strings.Join(names, "+")
+}
+
+// addSubtotal adds a row of a rollup query.  It returns true if the row is part of the joint strat.
+func (strt *Strat) addSubtotal(row chutils.Row) (joint bool, err error) {
+	nf := len(strt.fields)
+	flags := row[len(row)-nf:]
+	st := &subtotal{present: make([]bool, nf), key: row[:nf]}
+
+	joint = true
+	for ind, flag := range flags {
+		// with standard compatibility, grouping() is 1 if the field is aggregated over
+		g, ok := toFloat(flag)
+		if !ok {
+			return false, fmt.Errorf("(*Strat) addSubtotal: unexpected type for grouping %T", flag)
+		}
+		st.present[ind] = g == 0
+		joint = joint && st.present[ind]
+	}
+
+	if joint {
+		return true, nil
+	}
+
+	st.count = row[nf].(uint64)
+	if strt.measure != "" {
+		st.measure = row[nf+1].(float64)
+	}
+	strt.subtotals = append(strt.subtotals, st)
+
+	return false, nil
+}
+
+// depth returns the number of fields in the grouping set if it is a prefix of the fields, otherwise -1.
+func (st *subtotal) depth() int {
+	d := 0
+	for d < len(st.present) && st.present[d] {
+		d++
+	}
+
+	for ind := d; ind < len(st.present); ind++ {
+		if st.present[ind] {
+			return -1
+		}
+	}
+
+	return d
+}
+
+// only returns true if the grouping set consists of just field col.
+func (st *subtotal) only(col int) bool {
+	for ind, p := range st.present {
+		if p != (ind == col) {
+			return false
+		}
+	}
+
+	return true
+}
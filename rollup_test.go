@@ -0,0 +1,46 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/invertedv/chutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupingSets(t *testing.T) {
+	assert.Equal(t, "GROUPING SETS ((a,b,c), (a,b), (a), (b), (c), ())", groupingSets([]string{"a", "b", "c"}))
+	assert.Equal(t, "GROUPING SETS ((a), ())", groupingSets([]string{"a"}))
+}
+
+func TestStrat_Tree(t *testing.T) {
+	strt := &Strat{fields: []string{"state", "purpose"}, rollup: true}
+	rows := []chutils.Row{
+		{"CA", "P", uint64(3), uint8(0), uint8(0)},
+		{"CA", "C", uint64(2), uint8(0), uint8(0)},
+		{"NY", "P", uint64(4), uint8(0), uint8(0)},
+		{"CA", "", uint64(5), uint8(0), uint8(1)},
+		{"NY", "", uint64(4), uint8(0), uint8(1)},
+		{"", "P", uint64(7), uint8(1), uint8(0)},
+		{"", "C", uint64(2), uint8(1), uint8(0)},
+		{"", "", uint64(9), uint8(1), uint8(1)},
+	}
+
+	for _, row := range rows {
+		joint, e := strt.addSubtotal(row)
+		assert.Nil(t, e)
+		if joint {
+			assert.Nil(t, strt.addRow(row[:3]))
+		}
+	}
+	strt.rawKeys, strt.rawCount = strt.keys, strt.count
+
+	assert.Equal(t, uint64(9), strt.Total())
+	tree := strt.Tree()
+	assert.Equal(t, 2, len(tree.Children))
+	assert.Equal(t, uint64(5), tree.Children[0].Count)
+	assert.Equal(t, 2, len(tree.Children[0].Children))
+
+	margs := strt.Marginals()
+	assert.Equal(t, []uint64{7, 2}, margs[1].count)
+	assert.Equal(t, uint64(9), margs[0].N())
+}
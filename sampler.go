@@ -43,6 +43,7 @@ package sampler
 import (
 	"fmt"
 	"github.com/invertedv/utilities"
+	"math"
 	"strings"
 	"time"
 
@@ -52,16 +53,46 @@ import (
 	s "github.com/invertedv/chutils/sql"
 )
 
+// Other is the strat value assigned to strata that have been collapsed into a catch-all stratum.
+const Other = "__other__"
+
+// CollapsePolicy determines what happens to strata with fewer than MinCount rows.
+type CollapsePolicy int
+
+const (
+	CollapseNone     CollapsePolicy = 0 + iota // CollapseNone drops sparse strata
+	CollapseOther                              // CollapseOther sets the fields of sparse strata to Other, last field first, until they have minCount rows
+	CollapseNeighbor                           // CollapseNeighbor merges sparse strata into their nearest neighbor on the last ordered field, o.w. as CollapseOther
+)
+
 // Strat produces stratifications.
 type Strat struct {
-	fields       []string // field names from which to form strats
-	keys         [][]any  // each element is a combination of strat values
-	count        []uint64 // count of rows with Keys from corresponding slice element
-	Query        string   // Query to pull data for strats
-	minCount     int      // lower bound of counts for a strat to be included
-	sortByCounts bool     // if true, strats are sorted descending by count, o.w. sorted ascending by strat
+	fields       []string          // field names from which to form strats
+	types        []chutils.ChField // ClickHouse types of the fields
+	keys         [][]any           // each element is a combination of strat values
+	count        []uint64          // count of rows with Keys from corresponding slice element
+	Query        string            // Query to pull data for strats
+	minCount     int               // lower bound of counts for a strat to be included
+	collapse     CollapsePolicy    // how strata with fewer than minCount rows are handled
+	sortByCounts bool              // if true, strats are sorted descending by count, o.w. sorted ascending by strat
 	n            uint64
-	conn         *chutils.Connect // DB connection
+	conn         *chutils.Connect      // DB connection
+	specs        map[string]*fieldSpec // options for forming the values of fields
+	measure      string                // optional measure of the size of a stratum, such as sum(upb)
+	measures     []float64             // value of measure for the stratum from corresponding slice element
+	summaries    []*Summary            // optional summary statistics calculated within each stratum
+	rollup       bool                  // if true, Make also calculates subtotals, marginals and the total
+	keepLast     bool                  // if true, the last field isn't collapsed, e.g. the class label
+	subtotals    []*subtotal           // rows of the grouping sets other than the joint strat, in rollup mode
+
+	rawKeys     [][]any   // strat values as found in the data, before collapsing
+	rawCount    []uint64  // count of rows with rawKeys from corresponding slice element
+	rawMeasures []float64 // measure of rows with rawKeys from corresponding slice element
+	group       []int     // index into keys of the stratum each element of rawKeys is collapsed into
+
+	dropKeys     [][]any   // with CollapseNone, strata dropped for having fewer than minCount rows
+	dropCount    []uint64  // count of rows with dropKeys from corresponding slice element
+	dropMeasures []float64 // measure of rows with dropKeys from corresponding slice element
 }
 
 func NewStrat(query string, conn *chutils.Connect, sortByCounts bool) *Strat {
@@ -81,8 +112,76 @@ func (strt *Strat) MinCount(mc int) int {
 	return strt.minCount
 }
 
+// Collapse returns (and optionally sets) the policy for strata with fewer than MinCount rows.
+// The value is not updated if policy < 0.
+func (strt *Strat) Collapse(policy CollapsePolicy) CollapsePolicy {
+	if policy >= 0 {
+		strt.collapse = policy
+	}
+
+	return strt.collapse
+}
+
+// FieldOpts sets the options for how the values of field are formed.  Options previously set for field are replaced.
+func (strt *Strat) FieldOpts(field string, opts ...FieldOpt) {
+	if strt.specs == nil {
+		strt.specs = make(map[string]*fieldSpec)
+	}
+
+	strt.specs[field] = newFieldSpec(field, opts...)
+}
+
+// spec returns the *fieldSpec for field.
+func (strt *Strat) spec(field string) *fieldSpec {
+	if fs, ok := strt.specs[field]; ok {
+		return fs
+	}
+
+	return newFieldSpec(field)
+}
+
+// source returns a query that produces the values of fields from Query.
+func (strt *Strat) source(fields ...string) string {
+	exprs := make([]string, 0)
+	for _, fld := range fields {
+		if exp := strt.spec(fld).expr(fld); exp != fld {
+			exprs = append(exprs, fmt.Sprintf("%s AS %s", exp, fld))
+		}
+	}
+
+	if len(exprs) == 0 {
+		return strt.Query
+	}
+
+	// replace fields with their calculated values
+	return fmt.Sprintf("SELECT * REPLACE(%s) FROM (%s)", strings.Join(exprs, ", "), strt.Query)
+}
+
+// Measure returns (and optionally sets) the measure of the size of a stratum. The measure is a ClickHouse aggregate
+// expression, such as "sum(upb)", that is reported alongside the count.  The measure of a collapsed stratum is
+// calculated from its rows, so the measure needn't be additive.
+// The value is not updated if measure is empty.
+func (strt *Strat) Measure(measure string) string {
+	if measure != "" {
+		strt.measure = measure
+	}
+
+	return strt.measure
+}
+
+// measureCalc returns the aggregate of the measure for a query.  The aggregate is a Float64 that is nan if the measure
+// is NULL.
+func (strt *Strat) measureCalc() string {
+	return fmt.Sprintf("toFloat64(ifNull(%s, nan)) AS measure", strt.measure)
+}
+
+// Measures returns the value of the measure for each stratum. The slice is in the same order as Table.
+func (strt *Strat) Measures() []float64 {
+	return strt.measures
+}
+
 // N returns the total number of observations in the strats.
-// This does not include strats dropped if MinCount > 0.
+// This does not include strats dropped if MinCount > 0 and the collapse policy is CollapseNone.
 func (strt *Strat) N() uint64 {
 	return strt.n
 }
@@ -92,6 +191,11 @@ func (strt *Strat) Fields() []string {
 	return strt.fields
 }
 
+// Types returns the ClickHouse types of the strat fields
+func (strt *Strat) Types() []chutils.ChField {
+	return strt.types
+}
+
 // Table returns the elements of the strat table. The table is stored by row.
 // Each row is a stratum.
 func (strt *Strat) Table() (keys [][]any, counts []uint64) {
@@ -99,31 +203,86 @@ func (strt *Strat) Table() (keys [][]any, counts []uint64) {
 }
 
 func (strt *Strat) addRow(fieldVals chutils.Row) error {
-	if len(fieldVals)-1 != len(strt.fields) {
-		return fmt.Errorf("(*Strat) addRow: field count of %d and return row of %d elements", len(fieldVals)-1, len(strt.fields))
+	nCalc := 1 + len(strt.summaries) // number of calculated columns following the fields
+	if strt.measure != "" {
+		nCalc++
+	}
+
+	if len(fieldVals)-nCalc != len(strt.fields) {
+		return fmt.Errorf("(*Strat) addRow: field count of %d and return row of %d elements", len(fieldVals)-nCalc, len(strt.fields))
 	}
 	val := make([]any, len(strt.fields))
 	for ind := 0; ind < len(strt.fields); ind++ {
 		val[ind] = fieldVals[ind]
 	}
 	strt.keys = append(strt.keys, val)
-	cnt := fieldVals[len(fieldVals)-1].(uint64)
+	cnt := fieldVals[len(strt.fields)].(uint64)
 	strt.count = append(strt.count, cnt)
 	strt.n += cnt
+
+	col := len(strt.fields) + 1
+	if strt.measure != "" {
+		val, e := asFloat(fieldVals[col])
+		if e != nil {
+			return fmt.Errorf("(*Strat) addRow: measure %s: %v", strt.measure, e)
+		}
+		strt.measures = append(strt.measures, val)
+		col++
+	}
+
+	for ind, sm := range strt.summaries {
+		val, e := asFloat(fieldVals[col+ind])
+		if e != nil {
+			return fmt.Errorf("(*Strat) addRow: summary %s: %v", sm.Name(), e)
+		}
+		sm.Values = append(sm.Values, val)
+	}
+
 	return nil
 }
 
 // Make generates the strat table for the list of fields.
 func (strt *Strat) Make(fields ...string) error {
 	strt.fields = fields
+	strt.types = nil
 	strt.keys = nil
 	strt.count = nil
+	strt.measures = nil
+	strt.subtotals = nil
+	strt.dropKeys, strt.dropCount, strt.dropMeasures = nil, nil, nil
+	for _, sm := range strt.summaries {
+		sm.Values = nil
+	}
+
+	for _, fld := range fields {
+		if e := strt.spec(fld).resolve(strt.Query, strt.conn); e != nil {
+			return e
+		}
+	}
 
 	fieldsList := strings.Join(fields, ",")
-	qry := fmt.Sprintf("SELECT %s, count(*) AS n FROM (%s) GROUP BY %s ", fieldsList, strt.Query, fieldsList)
+	calcs := "count(*) AS n"
+	if strt.measure != "" {
+		calcs = fmt.Sprintf("%s, %s", calcs, strt.measureCalc())
+	}
+
+	if len(strt.summaries) > 0 {
+		calcs = fmt.Sprintf("%s, %s", calcs, strt.summaryCalcs())
+	}
+
+	groupBy, subtotals := fieldsList, ""
+	if strt.rollup {
+		var gCalcs string
+		gCalcs, subtotals = groupingCalcs(fields)
+		calcs = fmt.Sprintf("%s, %s", calcs, gCalcs)
+		groupBy = groupingSets(fields)
+	}
+
+	qry := fmt.Sprintf("SELECT %s, %s FROM (%s) GROUP BY %s ", fieldsList, calcs, strt.source(fields...), groupBy)
 
-	if strt.minCount > 0 {
-		qry = fmt.Sprintf("%s HAVING n >= %d", qry, strt.minCount)
+	// only the joint strat is subject to minCount.  Without rollup, the dropped strata are kept by dropSparse.
+	if strt.minCount > 0 && strt.collapse == CollapseNone && strt.rollup {
+		qry = fmt.Sprintf("%s HAVING n >= %d OR %s > 0", qry, strt.minCount, subtotals)
 	}
 	switch strt.sortByCounts {
 	case true:
@@ -132,6 +291,10 @@ func (strt *Strat) Make(fields ...string) error {
 		qry = fmt.Sprintf("%s ORDER BY %s", qry, fieldsList)
 	}
 
+	if strt.rollup {
+		qry = fmt.Sprintf("%s SETTINGS force_grouping_standard_compatibility = 1", qry)
+	}
+
 	rdr := s.NewReader(qry, strt.conn)
 
 	if e := rdr.Init("", chutils.MergeTree); e != nil {
@@ -146,6 +309,10 @@ func (strt *Strat) Make(fields ...string) error {
 		if fd.ChSpec.Base == chutils.ChFloat {
 			return fmt.Errorf("cant stratify on type float: %s", fld)
 		}
+		if strt.spec(fld).bucket > 0 && fd.ChSpec.Base != chutils.ChDate {
+			return fmt.Errorf("date bucketing requires a Date/DateTime field: %s", fld)
+		}
+		strt.types = append(strt.types, fd.ChSpec)
 	}
 
 	rows, _, e := rdr.Read(0, false)
@@ -155,12 +322,37 @@ func (strt *Strat) Make(fields ...string) error {
 
 	strt.n = 0
 	for ind := 0; ind < len(rows); ind++ {
-		if e := strt.addRow(rows[ind]); e != nil {
+		row := rows[ind]
+		if strt.rollup {
+			joint, e := strt.addSubtotal(row)
+			if e != nil {
+				return e
+			}
+
+			if !joint {
+				continue
+			}
+
+			row = row[:len(row)-len(fields)]
+		}
+
+		if e := strt.addRow(row); e != nil {
 			return e
 		}
 	}
 
-	return nil
+	strt.dropSparse()
+	strt.rawKeys, strt.rawCount, strt.rawMeasures = strt.keys, strt.count, strt.measures
+	strt.group = make([]int, len(strt.keys))
+	for ind := 0; ind < len(strt.group); ind++ {
+		strt.group[ind] = ind
+	}
+
+	if strt.minCount > 0 && strt.collapse != CollapseNone {
+		strt.collapseSparse()
+	}
+
+	return strt.summarizeGroups()
 }
 
 func format(x any) string {
@@ -172,31 +364,56 @@ func format(x any) string {
 	}
 }
 
+// format formats x, a value of the col-th strat field.
+func (strt *Strat) format(col int, x any) string {
+	if col < len(strt.fields) {
+		return strt.spec(strt.fields[col]).format(x)
+	}
+
+	return format(x)
+}
+
+// label returns the formatted values of a stratum separated by colons.
+func (strt *Strat) label(key []any) string {
+	vals := make([]string, len(key))
+	for ind, k := range key {
+		vals[ind] = strt.format(ind, k)
+	}
+
+	return strings.Join(vals, ":")
+}
+
 // Plot plots the count of observations for each strat from sampleTable
-func (strt *Strat) Plot(outDir, outFile string, imageTypes []utilities.PlotlyImage, show bool) error {
+func (strt *Strat) Plot(outDir, outFile string, imageTypes []utilities.PlotlyImage, show bool, opts ...PlotOpt) error {
+	return newPlotOpts(opts...).plot(strt.barFig(), nil, "Observation Count By Stratum", "Stratum", "Counts", false,
+		outDir, outFile, imageTypes, show)
+}
+
+// barFig returns the figure for Plot.
+func (strt *Strat) barFig() *grob.Fig {
 	x := make([]string, len(strt.count))
-	keys := make([]string, len(strt.fields))
 	for row, f := range strt.keys {
-		for col := 0; col < len(f); col++ {
-			keys[col] = fmt.Sprintf("%v", f[col])
-		}
-		x[row] = strings.Join(keys, ":")
+		x[row] = strt.label(f)
 	}
 	tr := &grob.Bar{X: x, Y: strt.count, Type: grob.TraceTypeBar}
-	fig := &grob.Fig{Data: grob.Traces{tr}}
-	return utilities.Plotter(fig, nil, &utilities.PlotDef{
-		Show:       show,
-		Title:      "Observation Count By Stratum",
-		XTitle:     "Stratum",
-		YTitle:     "Counts",
-		STitle:     "",
-		Legend:     false,
-		Height:     1200,
-		Width:      1600,
-		ImageTypes: imageTypes,
-		OutDir:     outDir,
-		FileName:   outFile,
-	})
+
+	return &grob.Fig{Data: grob.Traces{tr}}
+}
+
+// rows returns the headers and formatted values of every row of the strat table.
+func (strt *Strat) rows() (heads []string, rows [][]string) {
+	xHeads, xVals := strt.extraCols()
+	heads = append(append(append(heads, strt.fields...), "Count"), xHeads...)
+
+	rows = make([][]string, len(strt.count))
+	for row := 0; row < len(strt.count); row++ {
+		for col := 0; col < len(strt.fields); col++ {
+			rows[row] = append(rows[row], strt.format(col, strt.keys[row][col]))
+		}
+		rows[row] = append(append(rows[row], humanize.Comma(int64(strt.count[row]))), xVals[row]...)
+	}
+
+	return heads, rows
 }
 
 func (strt *Strat) String() string {
@@ -216,14 +433,39 @@ func (strt *Strat) String() string {
 	maxes := make([]int, len(strt.fields))
 	maxCnt := spaces // max width of count field
 
+	// additional columns such as the measure
+	xHeads, xVals := strt.extraCols()
+	xMaxes := make([]int, len(xHeads))
+	for col, h := range xHeads {
+		xMaxes[col] = len(h)
+	}
+
 	for row := 0; row < len(strt.count); row++ {
 		for col := 0; col < len(strt.fields); col++ {
 			if row == 0 {
 				maxes[col] = spaces + len(strt.fields[col])
 			}
-			maxes[col] = Max(maxes[col], len(format(strt.keys[row][col]))+spaces)
+			maxes[col] = Max(maxes[col], len(strt.format(col, strt.keys[row][col]))+spaces)
 		}
 		maxCnt = Max(maxCnt, len(humanize.Comma(int64(strt.count[row]))))
+		for col := 0; col < len(xHeads); col++ {
+			xMaxes[col] = Max(xMaxes[col], len(xVals[row][col]))
+		}
+	}
+
+	// line returns the display of a row of the table
+	line := func(row int) string {
+		str := ""
+		for col := 0; col < len(strt.fields); col++ {
+			str = fmt.Sprintf("%s%s", str, padder(strt.format(col, strt.keys[row][col]), maxes[col], true))
+		}
+		// pre-pend spaces so the RHS lines up
+		str = fmt.Sprintf("%s%s", str, padder(padder(humanize.Comma(int64(strt.count[row])), maxCnt, false), maxCnt+spaces, true))
+		for col := 0; col < len(xHeads); col++ {
+			str = fmt.Sprintf("%s%s", str, padder(padder(xVals[row][col], xMaxes[col], false), xMaxes[col]+spaces, true))
+		}
+
+		return str + "\n"
 	}
 
 	// headers
@@ -231,16 +473,15 @@ func (strt *Strat) String() string {
 	for col := 0; col < len(strt.fields); col++ {
 		str = fmt.Sprintf("%s%s", str, padder(strt.fields[col], maxes[col], true))
 	}
-	str = fmt.Sprintf("%s%s\n", str, padder("Count", maxCnt+spaces, true))
+	str = fmt.Sprintf("%s%s", str, padder("Count", maxCnt+spaces, true))
+	for col, h := range xHeads {
+		str = fmt.Sprintf("%s%s", str, padder(padder(h, xMaxes[col], false), xMaxes[col]+spaces, true))
+	}
+	str += "\n"
 
 	// first maxShow rows
 	for row := 0; row < Min(maxShow, len(strt.count)); row++ {
-		for col := 0; col < len(strt.fields); col++ {
-			str = fmt.Sprintf("%s%s", str, padder(format(strt.keys[row][col]), maxes[col], true))
-		}
-		// pre-pend spaces so the RHS lines up
-		str = fmt.Sprintf("%s%s", str, padder(padder(humanize.Comma(int64(strt.count[row])), maxCnt, false), maxCnt+spaces, true))
-		str += "\n"
+		str += line(row)
 	}
 
 	// last maxShow rows
@@ -252,12 +493,7 @@ func (strt *Strat) String() string {
 		start := Max(len(strt.count)-maxShow, maxShow)
 		finish := Min(len(strt.count), start+maxShow)
 		for row := start; row < finish; row++ {
-			for col := 0; col < len(strt.fields); col++ {
-				str = fmt.Sprintf("%s%s", str, padder(format(strt.keys[row][col]), maxes[col], true))
-			}
-			// pre-pend spaces so the RHS lines up
-			str = fmt.Sprintf("%s%s", str, padder(padder(humanize.Comma(int64(strt.count[row])), maxCnt, false), maxCnt+spaces, true))
-			str += "\n"
+			str += line(row)
 		}
 	}
 
@@ -267,25 +503,86 @@ func (strt *Strat) String() string {
 
 	str = fmt.Sprintf("%s    %d total obs", str, strt.n)
 
+	if strt.measure != "" {
+		str = fmt.Sprintf("%s\n    %s total %s", str, humanize.Commaf(sum(strt.measures)), strt.measure)
+	}
+
+	// subtotals
+	if margs := strt.Marginals(); len(strt.fields) > 1 && margs != nil {
+		for ind, marg := range margs {
+			str = fmt.Sprintf("%s\n\nSubtotals by %s\n%s", str, strt.fields[ind], marg.String())
+		}
+		str = fmt.Sprintf("%s\n\n    %s grand total obs", str, humanize.Comma(int64(strt.Total())))
+	}
+
 	return str
 }
 
+// extraCols returns the headers and formatted values of the columns displayed after Count by String.
+func (strt *Strat) extraCols() (heads []string, vals [][]string) {
+	vals = make([][]string, len(strt.count))
+
+	if strt.measure != "" {
+		heads = append(heads, strt.measure)
+		for row := 0; row < len(strt.count); row++ {
+			vals[row] = append(vals[row], humanize.Comma(int64(strt.measures[row])))
+		}
+	}
+
+	for _, sm := range strt.summaries {
+		heads = append(heads, sm.Name())
+		for row := 0; row < len(strt.count); row++ {
+			vals[row] = append(vals[row], fmt.Sprintf("%0.2f", sm.Values[row]))
+		}
+	}
+
+	return heads, vals
+}
+
 // Generator is used to produce stratified samples.
 type Generator struct {
 	// inputs
-	Query       string  // Query to fetch data to sample
-	sampleTable string  // table to create with sample
-	stratTable  string  // table to create with strats/sampling rates
-	targetTotal int     // total number of obs desired
-	minCount    uint64  // minimum # of obs to include a strat in sample (default: 1)
-	sampleCap   float64 // maximum sample rate for any strat (default: 0)
-	sortByCount bool    // if true, sort strats descending by count
+	Query          string                // Query to fetch data to sample
+	sampleTable    string                // table to create with sample
+	stratTable     string                // table to create with strats/sampling rates
+	targetTotal    int                   // total number of obs desired
+	minCount       uint64                // minimum # of obs to include a strat in sample (default: 1)
+	collapse       CollapsePolicy        // how strata with fewer than minCount obs are handled (default: CollapseNone)
+	sampleCap      float64               // maximum sample rate for any strat (default: 0)
+	sortByCount    bool                  // if true, sort strats descending by count
+	specs          map[string]*fieldSpec // options for forming the values of strat fields
+	measure        string                // optional measure of the size of a stratum, such as sum(upb)
+	balanceMeasure bool                  // if true, balance the measure rather than the row count
+	summaries      []*Summary            // summary statistics to calculate within each stratum
+	rollup         bool                  // if true, strats are made in rollup mode
+	fields         []string              // strat fields used if CalcRates is called without fields
+	seed           int64                 // if > 0, seed for the sampling draws, making the sample reproducible
+	splits         []Split               // if not nil, the splits the sampled rows are assigned to
+	auditTable     string                // if not empty, MakeTable records the build in this table
+	audited        []StratumCount        // counts of each stratum recorded in the audit table, set by FromAudit
+	hashKey        []string              // if not nil, rows are sampled by a hash of these columns
+	salt           string                // salt of the hash of hashKey
+	tiers          []int                 // if not nil, the increasing target totals of nested samples
+	tierBase       int                   // targetTotal before tiers were set
+	excludeSource  string                // if not empty, table or query of keys whose rows are never sampled
+	excludeKeys    []string              // columns that identify the rows to exclude
+	topUpTol       float64               // if > 0, MakeTable tops up strata short by more than this fraction
+	topUpKeys      []string              // columns that identify rows for the top-up
+	classLabel     string                // if not empty, the label column whose classes are sampled to classRatio
+	classRatio     []float64             // relative sizes of the classes, smallest first
+	ppsSize        string                // if not empty, rows are sampled with probability proportional to this column
+	partitions     []string              // queries of the data Query is the union of, once Refresh has added to it
 
 	// calculated fields
 	sampleRate   []float64        // calculated sample rates to achieve a balanced sample
 	strats       *Strat           // strats calculated from Query data
 	sampleStrats *Strat           // strats calculated from sampled data
 	expCaptured  int              // expected size of sampleTable
+	expMeasure   float64          // expected measure of sampleTable, if balancing on the measure
+	trace        []Iteration      // iterations of the calculation of sampleRate
+	tierRates    [][]float64      // sample rates of each tier, if tiers are set
+	excluded     *Strat           // strats of the rows excluded from Query
+	topUpObs     int              // rows added to sampleTable by the top-up
 	actCaptured  int              // actual size of sampleTable
 	makeQuery    string           // Query used to create sampleTable
 	conn         *chutils.Connect // connection to DB
@@ -336,6 +633,48 @@ func (gn *Generator) MinCount(mc int) uint64 {
 	return gn.minCount
 }
 
+// Collapse returns (and optionally sets) the policy for strata with fewer than MinCount obs.
+// With CollapseNone, these strata are not sampled.  Otherwise, they are sampled at the rate of the stratum they are
+// collapsed into.
+// The value is not updated if policy < 0.
+func (gn *Generator) Collapse(policy CollapsePolicy) CollapsePolicy {
+	if policy < 0 {
+		return gn.collapse
+	}
+
+	gn.collapse = policy
+	gn.reset()
+
+	return gn.collapse
+}
+
+// FieldOpts sets the options for how the values of the strat field are formed.
+// Options previously set for field are replaced.
+func (gn *Generator) FieldOpts(field string, opts ...FieldOpt) {
+	if gn.specs == nil {
+		gn.specs = make(map[string]*fieldSpec)
+	}
+
+	gn.specs[field] = newFieldSpec(field, opts...)
+	gn.reset()
+}
+
+// Measure returns (and optionally sets) the measure of the size of a stratum, such as "sum(upb)".
+// If balance is true, the sample is balanced on the measure rather than the row count.  In this case, TargetTotal is
+// in units of the measure -- e.g. the total balance to sample.  CalcRates returns an error if the measure is NULL for
+// a stratum being balanced.
+// The values are not updated if measure is empty.
+func (gn *Generator) Measure(measure string, balance bool) string {
+	if measure == "" {
+		return gn.measure
+	}
+
+	gn.measure, gn.balanceMeasure = measure, balance
+	gn.reset()
+
+	return gn.measure
+}
+
 // SampleCap returns (and optional sets) the maximum sampling rate for strats.
 // The value is not updated if cap <= 0.0 or cap > 1.0
 func (gn *Generator) SampleCap(sCap float64) float64 {
@@ -349,42 +688,240 @@ func (gn *Generator) SampleCap(sCap float64) float64 {
 	return gn.sampleCap
 }
 
+// Seed returns (and optionally sets) the seed of the random draws that select rows. With a seed, the draws are a
+// hash of the row number and seed, so the sample is reproducible if the rows of Query are returned in the same order.
+// A seed of 0 uses ClickHouse's random number generator.
+// The value is not updated if seed < 0.
+func (gn *Generator) Seed(seed int64) int64 {
+	if seed < 0 {
+		return gn.seed
+	}
+
+	gn.seed = seed
+
+	return gn.seed
+}
+
+// Split is a named portion of the sample, such as a training or validation set.
+type Split struct {
+	Name     string  `yaml:"name" json:"name"`         // Name is the value of the split column for rows in the split
+	Fraction float64 `yaml:"fraction" json:"fraction"` // Fraction is the fraction of the sample in the split
+}
+
+// Splits assigns the rows of sampleTable to splits.  sampleTable has a column, split, with the name of the split of
+// each row. The fractions must sum to 1.
+func (gn *Generator) Splits(splits ...Split) error {
+	total := 0.0
+	for _, sp := range splits {
+		if sp.Fraction <= 0.0 || sp.Name == "" {
+			return fmt.Errorf("(*Generator) Splits: splits must have a name and positive fraction")
+		}
+		total += sp.Fraction
+	}
+
+	if len(splits) > 0 && (total < 0.9999 || total > 1.0001) {
+		return fmt.Errorf("(*Generator) Splits: fractions sum to %v, not 1", total)
+	}
+
+	gn.splits = splits
+
+	return nil
+}
+
+// draw returns the SQL expression for a uniform draw on [0,1] for each row of the source aliased as alias.  Draws
+// with different values of stream are independent.
+func (gn *Generator) draw(alias string, stream int) string {
+	if gn.hashKey != nil {
+		keys := make([]string, len(gn.hashKey))
+		for ind, k := range gn.hashKey {
+			keys[ind] = fmt.Sprintf("%s.%s", alias, k)
+		}
+
+		args := fmt.Sprintf("%s, %s", strings.Join(keys, ", "), literal(gn.salt))
+		if stream > 0 {
+			args = fmt.Sprintf("%s, %d", args, stream)
+		}
+
+		return fmt.Sprintf("cityHash64(%s) / 18446744073709551615.0", args)
+	}
+
+	if gn.seed > 0 {
+		return fmt.Sprintf("cityHash64(rowNumberInAllBlocks(), %d, %d) / 18446744073709551615.0", gn.seed, stream)
+	}
+
+	if stream == 0 {
+		return "rand32(rowNumberInAllBlocks()) / 4294967295.0"
+	}
+
+	return fmt.Sprintf("rand32(rowNumberInAllBlocks() + %d) / 4294967295.0", stream)
+}
+
+// HashSample selects rows by a hash of the key columns and salt rather than a random draw.  A row is sampled if
+// cityHash64(keys, salt) < rate * 2^64.  Membership is then deterministic and monotone in the sampling rate: if the
+// rate of a stratum doesn't fall, rows sampled before are sampled again, so refreshes of a growing source only add
+// rows.  The keys should uniquely identify a row.  If keys is empty, rows are selected by a random draw.
+func (gn *Generator) HashSample(salt string, keys ...string) {
+	gn.salt, gn.hashKey = salt, keys
+	if len(keys) == 0 {
+		gn.hashKey = nil
+	}
+}
+
+// splitCalc returns the SQL expression that assigns rows to splits using the uniform draw u.
+func (gn *Generator) splitCalc(u string) string {
+	conds := make([]string, 0)
+	cum := 0.0
+	for ind, sp := range gn.splits {
+		if ind == len(gn.splits)-1 {
+			conds = append(conds, literal(sp.Name))
+			break
+		}
+
+		cum += sp.Fraction
+		conds = append(conds, fmt.Sprintf("%s < %v, %s", u, cum, literal(sp.Name)))
+	}
+
+	if len(conds) == 1 {
+		return conds[0]
+	}
+
+	return fmt.Sprintf("multiIf(%s)", strings.Join(conds, ", "))
+}
+
 // SampleRates returns the calculated sample rates. The slice is in the same order as Strats.
 func (gn *Generator) SampleRates() []float64 {
 	return gn.sampleRate
 }
 
 // CalcRates calculates the sampling rate for each strat to achieve a balanced sample with a total size of TargetTotal.
-// fields is the set of fields to stratify on.
+// If the Generator is balancing on a measure, the sample is balanced on the measure rather than the row count.
+// fields is the set of fields to stratify on.  If fields are not specified, the fields of the Spec the Generator was
+// made from are used.
 func (gn *Generator) CalcRates(fields ...string) error {
-	const (
-		maxIter = 5
-		tol     = 0.01
-	)
+	if fields == nil {
+		fields = gn.fields
+	}
+
+	if gn.ppsSize != "" && (gn.tiers != nil || gn.classLabel != "" || gn.balanceMeasure) {
+		return fmt.Errorf("(*Generator) CalcRates: cannot use PPS sampling with tiers, class balancing or a balanced measure")
+	}
+
+	if gn.classLabel != "" {
+		if gn.tiers != nil {
+			return fmt.Errorf("(*Generator) CalcRates: cannot balance classes with tiers")
+		}
+
+		if gn.balanceMeasure {
+			return fmt.Errorf("(*Generator) CalcRates: cannot balance classes and a measure")
+		}
+
+		if gn.topUpTol > 0.0 {
+			return fmt.Errorf("(*Generator) CalcRates: cannot top up class-balanced samples")
+		}
+
+		fields = gn.classFields(fields)
+	}
+
+	if e := gn.topUpCheck(); e != nil {
+		return e
+	}
 
 	if fields == nil {
 		return fmt.Errorf("(*Generator) CalcRates: must specify strat fields")
 	}
-	gn.strats = NewStrat(gn.Query, gn.conn, gn.sortByCount)
+	gn.strats = NewStrat(gn.available(gn.Query), gn.conn, gn.sortByCount)
 	gn.strats.MinCount(int(gn.minCount))
+	gn.strats.Collapse(gn.collapse)
+	gn.strats.specs = copySpecs(gn.specs)
+	gn.strats.Measure(gn.measure)
+	gn.strats.summaries = copySummaries(gn.summaries)
+	gn.strats.Rollup(gn.rollup)
+	gn.strats.keepLast = gn.classLabel != ""
 
 	if e := gn.strats.Make(fields...); e != nil {
 		return e
 	}
 
-	tot := 0
-	for _, c := range gn.strats.count {
-		tot += int(c)
+	if e := gn.makeExcluded(); e != nil {
+		return e
 	}
 
-	gn.sampleRate = make([]float64, len(gn.strats.count))
+	return gn.allocateRates()
+}
+
+// allocateRates calculates the sampling rates of the strata of gn.strats.  If the Generator is balancing on a
+// measure, the measure must be finite in every stratum: a stratum whose measure is NULL can't be balanced.
+func (gn *Generator) allocateRates() error {
+	// sizes of the strata we're balancing
+	sizes := make([]float64, len(gn.strats.count))
+	for ind, c := range gn.strats.count {
+		sizes[ind] = float64(c)
+		if !gn.balanceMeasure {
+			continue
+		}
+
+		sizes[ind] = gn.strats.measures[ind]
+		if math.IsNaN(sizes[ind]) || math.IsInf(sizes[ind], 0) {
+			return fmt.Errorf("(*Generator) CalcRates: measure %s is NULL or not finite for stratum %s",
+				gn.measure, gn.strats.label(gn.strats.keys[ind]))
+		}
+	}
+
+	var captured int
+	if gn.classLabel != "" {
+		gn.sampleRate, gn.trace = gn.classRates(), nil
+		for ind, c := range gn.strats.count {
+			captured += int(gn.sampleRate[ind] * float64(c))
+		}
+	} else {
+		gn.sampleRate, captured, gn.trace = allocate(sizes, gn.targetTotal, gn.sampleCap)
+	}
+
+	gn.tierRates = nil
+	if gn.tiers != nil {
+		gn.tierRates = nestedRates(sizes, gn.tiers, gn.sampleCap)
+		gn.sampleRate = gn.tierRates[len(gn.tierRates)-1]
+		captured = 0
+		for ind, sz := range sizes {
+			captured += int(gn.sampleRate[ind] * sz)
+		}
+	}
+
+	gn.expCaptured, gn.expMeasure = captured, 0.0
+	if gn.balanceMeasure {
+		gn.expCaptured, gn.expMeasure = 0, float64(captured)
+		for ind, c := range gn.strats.count {
+			gn.expCaptured += int(gn.sampleRate[ind] * float64(c))
+		}
+	}
+
+	return nil
+}
+
+// Iteration is an iteration of the algorithm that calculates the sampling rates.
+type Iteration struct {
+	Target   int // Target is the sample still desired at the start of the iteration
+	Free     int // Free is the number of strata with observations available at the end of the iteration
+	LostObs  int // LostObs is the shortfall of strata that don't have enough observations to meet their target
+	Captured int // Captured is the expected size of the sample at the end of the iteration
+}
+
+// allocate calculates the sampling rate for each stratum to achieve a balanced sample with a total size of target.
+// sizes are the sizes of the strata, either row counts or a measure. The return captured is the expected size of the
+// sample and trace has the details of each iteration.
+func allocate(sizes []float64, target int, sampleCap float64) (rates []float64, captured int, trace []Iteration) {
+	const (
+		maxIter = 5
+		tol     = 0.01
+	)
+
+	rates = make([]float64, len(sizes))
 	iter := true
 
-	target := gn.targetTotal     // target # of obs to capture
-	free := len(gn.strats.count) // number of strata that have data available
+	targetTotal := target // target size to capture
+	free := len(sizes)    // number of strata that have data available
 	iterCount := 0
-	capturedObs := 0                                // total obs we've captured toward the goal of gn.targetTotal
-	tolerance := int(tol * float64(gn.targetTotal)) // call it good if we've gotten within this many obs of target
+	tolerance := int(tol * float64(targetTotal)) // call it good if we've gotten within this many obs of target
 
 	// The approach is to calculate a target sample for each strat based on how many obs we need in total.
 	// If there are enough in each stratum, this will take one try.  If some strata don't have enough obs,
@@ -393,35 +930,34 @@ func (gn *Generator) CalcRates(fields ...string) error {
 		lostObs := 0
 		perStrat := float64(target) / float64(free)
 
-		for ind, c := range gn.strats.count {
-			if gn.sampleRate[ind] >= 1.0 {
+		for ind, c := range sizes {
+			if rates[ind] >= 1.0 {
 				continue
 			}
 
-			rate := perStrat / float64(c)
+			rate := perStrat / c
 
 			// taking more than allowed?
-			if rate+gn.sampleRate[ind] > gn.sampleCap {
-				rate = gn.sampleCap - gn.sampleRate[ind]
+			if rate+rates[ind] > sampleCap {
+				rate = sampleCap - rates[ind]
 			}
 
-			capturedObs += int(rate * float64(c))
-			gn.sampleRate[ind] += rate
+			captured += int(rate * c)
+			rates[ind] += rate
 
-			if float64(c) < perStrat {
+			if c < perStrat {
 				lostObs += int(perStrat) - int(c)
 				free--
 			}
 		}
 
-		target = gn.targetTotal - capturedObs
+		trace = append(trace, Iteration{Target: target, Free: free, LostObs: lostObs, Captured: captured})
+		target = targetTotal - captured
 		iterCount++
 		iter = iterCount < maxIter && lostObs > tolerance && free > 0
 	}
 
-	gn.expCaptured = capturedObs
-
-	return nil
+	return rates, captured, trace
 }
 
 // MakeTable creates sampleTable and stratTable.
@@ -430,44 +966,70 @@ func (gn *Generator) MakeTable(timeOut int64) error {
 		return fmt.Errorf("(*Generator) MakeTable: must run CalcRates first")
 	}
 
-	if e := gn.Save(); e != nil {
+	if e := gn.topUpCheck(); e != nil {
 		return e
 	}
 
-	qry := fmt.Sprintf("SELECT\n  a.*\nFROM\n  (%s) AS a\nJOIN\n  %s AS b\n ON \n", gn.Query, gn.stratTable)
-	joins := make([]string, 0)
-
-	for _, f := range gn.strats.fields {
-		joins = append(joins, fmt.Sprintf("a.%s = b.%s\n", f, f))
+	if e := gn.Save(); e != nil {
+		return e
 	}
 
-	qry = fmt.Sprintf("%s %s", qry, strings.Join(joins, " AND "))
-	qry = fmt.Sprintf("%s WHERE rand32(rowNumberInAllBlocks()) / 4294967295.0 < b.sampleRate\n", qry)
-	gn.makeQuery = qry
-	rdr := s.NewReader(qry, gn.conn)
+	gn.makeQuery = gn.sampleQuery(gn.Query)
+	chutils.WithTimeOut(timeOut)(gn.conn)
 
-	if e := rdr.Init("", chutils.MergeTree); e != nil {
+	if e := makeTable(gn.makeQuery, gn.sampleTable, gn.conn); e != nil {
 		return e
 	}
 
-	if e := rdr.TableSpec().Create(gn.conn, gn.sampleTable); e != nil {
+	if e := gn.makeSampleStrats(); e != nil {
 		return e
 	}
 
-	rdr.Name = gn.sampleTable
+	if gn.topUpTol > 0.0 {
+		if e := gn.topUp(); e != nil {
+			return e
+		}
+	}
 
-	chutils.WithTimeOut(timeOut)(gn.conn)
+	if gn.auditTable != "" {
+		return gn.audit()
+	}
+
+	return nil
+}
 
-	if e := rdr.Insert(); e != nil {
+// makeTable creates table from the results of qry.
+func makeTable(qry, table string, conn *chutils.Connect) error {
+	rdr := s.NewReader(qry, conn)
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		return e
+	}
+
+	if e := rdr.TableSpec().Create(conn, table); e != nil {
 		return e
 	}
 
-	qry = fmt.Sprintf("SELECT * FROM %s", gn.sampleTable)
+	rdr.Name = table
+
+	return rdr.Insert()
+}
+
+// makeSampleStrats makes the strats of sampleTable.
+func (gn *Generator) makeSampleStrats() error {
+	qry := fmt.Sprintf("SELECT * FROM %s", gn.sampleTable)
 	gn.sampleStrats = NewStrat(qry, gn.conn, gn.sortByCount)
+	gn.sampleStrats.specs = copySpecs(gn.strats.specs)
+	gn.sampleStrats.Measure(gn.strats.measure)
+	gn.sampleStrats.Rollup(gn.rollup)
 	if e := gn.sampleStrats.Make(gn.strats.fields...); e != nil {
 		return e
 	}
+	gn.sampleStrats.collapseLike(gn.strats)
+	if e := gn.sampleStrats.summarizeGroups(); e != nil {
+		return e
+	}
 
+	gn.actCaptured = 0
 	for ind := 0; ind < len(gn.sampleStrats.count); ind++ {
 		gn.actCaptured += int(gn.sampleStrats.count[ind])
 	}
@@ -475,20 +1037,124 @@ func (gn *Generator) MakeTable(timeOut int64) error {
 	return nil
 }
 
+// sampleQuery returns the query that selects the sample from query, which has the columns of Query.
+func (gn *Generator) sampleQuery(query string) string {
+	if gn.ppsSize != "" {
+		return gn.ppsQuery(query)
+	}
+
+	qry := fmt.Sprintf("SELECT\n  %s\nFROM\n  (%s) AS a\nJOIN\n  %s AS b\n ON \n", gn.sampleCols(), gn.available(query), gn.stratTable)
+	qry = fmt.Sprintf("%s %s", qry, gn.joins("a", "b"))
+
+	return fmt.Sprintf("%s WHERE %s < b.sampleRate\n", qry, gn.draw("a", 0))
+}
+
+// sampleCols returns the columns of sampleTable selected from the source aliased as a joined to stratTable aliased
+// as b.
+func (gn *Generator) sampleCols() string {
+	sel := "a.*"
+	if gn.splits != nil {
+		sel = fmt.Sprintf("a.*,\n  %s AS split", gn.splitCalc(gn.draw("a", 1)))
+	}
+
+	if gn.tiers != nil {
+		sel = fmt.Sprintf("%s,\n  %s AS tier", sel, gn.tierCalc(gn.draw("a", 0)))
+	}
+
+	if gn.classLabel != "" {
+		sel = fmt.Sprintf("%s,\n  1.0 / b.sampleRate AS weight", sel)
+	}
+
+	return sel
+}
+
+// joins returns the conditions that join the source aliased as src to the strats table aliased as strt.
+func (gn *Generator) joins(src, strt string) string {
+	joins := make([]string, 0)
+	for _, f := range gn.strats.fields {
+		joins = append(joins, fmt.Sprintf("%s = %s.%s\n", gn.strats.spec(f).expr(src+"."+f), strt, f))
+	}
+
+	return strings.Join(joins, " AND ")
+}
+
 // Save saves stratTable to the DB.
 func (gn *Generator) Save() error {
-	const sep = ","
-
 	if len(gn.strats.keys) == 0 {
 		return fmt.Errorf("(*Strat)Save: cannot save empty strats")
 	}
 
+	td, e := gn.stratTableDef()
+	if e != nil {
+		return e
+	}
+
+	if e := td.Create(gn.conn, gn.stratTable); e != nil {
+		return e
+	}
+
+	return gn.insertStrats(gn.stratTable, gn.sampleRate)
+}
+
+// insertStrats inserts the strats with sampling rates rates into table, which has the columns of stratTable.
+func (gn *Generator) insertStrats(table string, rates []float64) error {
+	const sep = ","
+
+	// stratTable has a row for each stratum as found in the data. If strata have been collapsed, the sampling rate is
+	// that of the stratum it's collapsed into.
+	keys, counts := gn.strats.rawKeys, gn.strats.rawCount
+	collapsed := gn.strats.collapsed()
+	wtr := s.NewWriter(table, gn.conn)
+
+	for row := 0; row < len(counts); row++ {
+		line := make([]byte, 0)
+		grp := gn.strats.group[row]
+
+		for col := 0; col < len(gn.strats.fields); col++ {
+			line = append(line, chutils.WriteElement(keys[row][col], sep, wtr.Text())...)
+		}
+		line = append(line, chutils.WriteElement(counts[row], sep, wtr.Text())...)
+		if len(rates) > 0 {
+			line = append(line, chutils.WriteElement(rates[grp], sep, wtr.Text())...)
+		}
+		for _, rates := range gn.tierRates {
+			line = append(line, chutils.WriteElement(rates[grp], sep, wtr.Text())...)
+		}
+		if gn.strats.measure != "" {
+			line = append(line, chutils.WriteElement(gn.strats.rawMeasures[row], sep, wtr.Text())...)
+		}
+		for _, sm := range gn.strats.summaries {
+			line = append(line, chutils.WriteElement(sm.raw[row], sep, wtr.Text())...)
+		}
+		if collapsed {
+			line = append(line, chutils.WriteElement(gn.strats.label(gn.strats.keys[grp]), sep, wtr.Text())...)
+		}
+		char := byte(' ')
+		if wtr.EOL() != 0 {
+			char = byte(wtr.EOL())
+		}
+		line[len(line)-1] = char
+
+		if _, e := wtr.Write(line); e != nil {
+			return e
+		}
+	}
+
+	if e := wtr.Insert(); e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// stratTableDef returns the TableDef of stratTable.
+func (gn *Generator) stratTableDef() (*chutils.TableDef, error) {
 	// build TableDef of output table
 	fds := make(map[int]*chutils.FieldDef)
 
 	for ind := 0; ind < len(gn.strats.fields); ind++ {
 		ch := chutils.ChField{}
-		switch gn.strats.keys[0][ind].(type) {
+		switch gn.strats.rawKeys[0][ind].(type) {
 		case int32:
 			ch.Base, ch.Length = chutils.ChInt, 32
 		case int64:
@@ -498,7 +1164,7 @@ func (gn *Generator) Save() error {
 		case time.Time:
 			ch.Base = chutils.ChDate
 		default:
-			return fmt.Errorf("unsupported type")
+			return nil, fmt.Errorf("unsupported type")
 		}
 		fd := chutils.NewFieldDef(gn.strats.fields[ind], ch, "", nil, nil, 0)
 		fds[ind] = fd
@@ -514,40 +1180,32 @@ func (gn *Generator) Save() error {
 		fds[n] = fd
 	}
 
-	td := chutils.NewTableDef(gn.strats.fields[0], chutils.MergeTree, fds)
-
-	if e := td.Create(gn.conn, gn.stratTable); e != nil {
-		return e
+	for ind := range gn.tierRates {
+		n++
+		fd = chutils.NewFieldDef(fmt.Sprintf("tierRate%d", ind+1), chutils.ChField{Base: chutils.ChFloat, Length: 64},
+			fmt.Sprintf("sample rate of tier %d", gn.tiers[ind]), nil, nil, 0)
+		fds[n] = fd
 	}
 
-	wtr := s.NewWriter(gn.stratTable, gn.conn)
-
-	for row := 0; row < len(gn.strats.count); row++ {
-		line := make([]byte, 0)
-
-		for col := 0; col < len(gn.strats.fields); col++ {
-			line = append(line, chutils.WriteElement(gn.strats.keys[row][col], sep, wtr.Text())...)
-		}
-		line = append(line, chutils.WriteElement(gn.strats.count[row], sep, wtr.Text())...)
-		if len(gn.sampleRate) > 0 {
-			line = append(line, chutils.WriteElement(gn.sampleRate[row], sep, wtr.Text())...)
-		}
-		char := byte(' ')
-		if wtr.EOL() != 0 {
-			char = byte(wtr.EOL())
-		}
-		line[len(line)-1] = char
+	if gn.strats.measure != "" {
+		n++
+		fd = chutils.NewFieldDef("measure", chutils.ChField{Base: chutils.ChFloat, Length: 64}, gn.strats.measure, nil, nil, 0)
+		fds[n] = fd
+	}
 
-		if _, e := wtr.Write(line); e != nil {
-			return e
-		}
+	for _, sm := range gn.strats.summaries {
+		n++
+		fd = chutils.NewFieldDef(sm.Name(), chutils.ChField{Base: chutils.ChFloat, Length: 64}, "", nil, nil, 0)
+		fds[n] = fd
 	}
 
-	if e := wtr.Insert(); e != nil {
-		return e
+	if gn.strats.collapsed() {
+		n++
+		fd = chutils.NewFieldDef("stratum", chutils.ChField{Base: chutils.ChString}, "collapsed stratum", nil, nil, 0)
+		fds[n] = fd
 	}
 
-	return nil
+	return chutils.NewTableDef(gn.strats.fields[0], chutils.MergeTree, fds), nil
 }
 
 // Marginals generates the strats of each field we're stratifying on.
@@ -557,15 +1215,23 @@ func (gn *Generator) Marginals() ([]*Strat, string, error) {
 	}
 
 	qry := fmt.Sprintf("SELECT * FROM %s", gn.sampleTable)
-	strats := make([]*Strat, 0)
 	str := ""
 
-	for _, f := range gn.strats.fields {
-		actStrat := NewStrat(qry, gn.conn, gn.sortByCount)
-		if e := actStrat.Make(f); e != nil {
-			return nil, "", e
+	// sampleStrats made in rollup mode have the marginals
+	strats := gn.sampleStrats.Marginals()
+	for ind, f := range gn.strats.fields {
+		var actStrat *Strat
+		switch strats != nil {
+		case true:
+			actStrat = strats[ind]
+		case false:
+			actStrat = NewStrat(qry, gn.conn, gn.sortByCount)
+			actStrat.specs = copySpecs(gn.strats.specs)
+			if e := actStrat.Make(f); e != nil {
+				return nil, "", e
+			}
+			strats = append(strats, actStrat)
 		}
-		strats = append(strats, actStrat)
 		str = fmt.Sprintf("%s\nMarginal Distribution of %s", str, f)
 		str = fmt.Sprintf("%s\n%s\n", str, actStrat)
 	}
@@ -578,14 +1244,26 @@ func (gn *Generator) String() string {
 	str = fmt.Sprintf("%sStrats Table: %s\n", str, gn.stratTable)
 	str = fmt.Sprintf("%sSample Table: %s\n", str, gn.sampleTable)
 	str = fmt.Sprintf("%sMin Count: %s\n", str, humanize.Comma(int64(gn.minCount)))
+	str = fmt.Sprintf("%sCollapse: %v\n", str, gn.collapse)
 	str = fmt.Sprintf("%sSampling Cap: %0.2f\n", str, gn.sampleCap)
 	if gn.strats == nil {
 		return str
 	}
-	str = fmt.Sprintf("%s\nTarget # Obs:%d\n", str, gn.targetTotal)
+	target := "# Obs"
+	if gn.balanceMeasure {
+		target = gn.measure
+	}
+	str = fmt.Sprintf("%s\nTarget %s:%d\n", str, target, gn.targetTotal)
 	str = fmt.Sprintf("%sExpected # Obs: %v", str, humanize.Comma(int64(gn.expCaptured)))
+	if gn.balanceMeasure {
+		str = fmt.Sprintf("%s\nExpected %s: %v", str, gn.measure, humanize.Commaf(gn.expMeasure))
+	}
 	if gn.sampleStrats != nil {
-		str = fmt.Sprintf("%s\nActual # Obs: %v\n\nSample Table Strats\n", str, humanize.Comma(int64(gn.actCaptured)))
+		str = fmt.Sprintf("%s\nActual # Obs: %v\n", str, humanize.Comma(int64(gn.actCaptured)))
+		if gn.topUpTol > 0.0 {
+			str = fmt.Sprintf("%sTop-Up # Obs: %v\n", str, humanize.Comma(int64(gn.topUpObs)))
+		}
+		str = fmt.Sprintf("%s\nSample Table Strats\n", str)
 		str = fmt.Sprintf("%s%s", str, gn.sampleStrats.String())
 		_, marg, e := gn.Marginals()
 		if e != nil {
@@ -598,11 +1276,25 @@ func (gn *Generator) String() string {
 	str = fmt.Sprintf("%s\nInput Table Strats:\n", str)
 	str = fmt.Sprintf("%s\n%s", str, gn.strats.String())
 
+	if gn.excluded != nil {
+		str = fmt.Sprintf("%s\nExcluded Rows (source: %s):\n\n%s", str, gn.excludeSource, gn.exclusionString())
+	}
+
 	return str
 }
 
 func (gn *Generator) reset() {
 	gn.strats, gn.sampleStrats, gn.sampleRate, gn.expCaptured, gn.actCaptured, gn.makeQuery = nil, nil, nil, 0, 0, ""
+	gn.expMeasure, gn.trace, gn.tierRates, gn.excluded, gn.topUpObs = 0.0, nil, nil, nil, 0
+}
+
+func sum(x []float64) float64 {
+	tot := 0.0
+	for _, xv := range x {
+		tot += xv
+	}
+
+	return tot
 }
 
 func padder(inStr string, padTo int, appendTo bool) string {
@@ -1,7 +1,9 @@
 package sampler
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"testing"
 
@@ -67,3 +69,41 @@ func TestGenerator_MakeTable(t *testing.T) {
 	e = gen.SampleStrats().Plot("", "", nil, true)
 	assert.Nil(t, e)
 }
+
+func TestAllocate(t *testing.T) {
+	// all strata have enough obs
+	rates, captured, _ := allocate([]float64{1000, 2000, 4000}, 300, 1.0)
+	assert.Equal(t, []float64{0.1, 0.05, 0.025}, rates)
+	assert.Equal(t, 300, captured)
+
+	// the first stratum is capped, so the others make up the difference
+	rates, captured, _ = allocate([]float64{100, 2000, 4000}, 900, 0.5)
+	assert.Equal(t, 0.5, rates[0])
+	assert.InDelta(t, 0.2125, rates[1], 1e-6)
+	assert.InDelta(t, 900, captured, 2)
+}
+
+func TestGenerator_allocateRatesNull(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	gn.Measure("sum(upb)", true)
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.strats.fields = []string{"state"}
+	gn.strats.keys, gn.strats.count = [][]any{{"TX"}, {"VT"}}, []uint64{100, 50}
+
+	// every upb in VT is NULL
+	gn.strats.measures = []float64{10000, math.NaN()}
+	e := gn.allocateRates()
+	assert.NotNil(t, e)
+	assert.Contains(t, e.Error(), "stratum VT")
+
+	// the measure is reported but not balanced on
+	gn.balanceMeasure = false
+	assert.Nil(t, gn.allocateRates())
+	assert.Equal(t, []float64{1, 1}, gn.sampleRate)
+
+	js, e := json.Marshal(gn)
+	assert.Nil(t, e)
+	gnr := &Generator{}
+	assert.Nil(t, json.Unmarshal(js, gnr))
+	assert.True(t, math.IsNaN(gnr.strats.measures[1]))
+}
@@ -0,0 +1,435 @@
+package sampler
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+	s "github.com/invertedv/chutils/sql"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the declarative specification of a sampling job.  It is read from YAML or JSON by ParseSpec.
+// For example:
+//
+//	query: SELECT * FROM bk.loans
+//	sampleTable: tmp.sample
+//	stratTable: tmp.strat
+//	targetTotal: 100000
+//	sampleCap: 0.5
+//	minCount: 100
+//	collapse: other
+//	seed: 42
+//	fields:
+//	  - name: state
+//	    topN: 10
+//	  - name: fico
+//	    bins: [600, 650, 700, 750]
+//	  - name: month
+//	    dateBucket: quarter
+//	splits:
+//	  - name: train
+//	    fraction: 0.8
+//	  - name: test
+//	    fraction: 0.2
+type Spec struct {
+	Query          string         `yaml:"query"`          // Query is the query that fetches the data to sample
+	SampleTable    string         `yaml:"sampleTable"`    // SampleTable is the table to create with the sample
+	StratTable     string         `yaml:"stratTable"`     // StratTable is the table to create with the strats
+	Fields         []*FieldConfig `yaml:"fields"`         // Fields are the strat fields
+	TargetTotal    int            `yaml:"targetTotal"`    // TargetTotal is the target size of the sample
+	SampleCap      float64        `yaml:"sampleCap"`      // SampleCap is the maximum sampling rate (default: 1)
+	MinCount       int            `yaml:"minCount"`       // MinCount is the minimum # of rows for a stratum
+	Collapse       string         `yaml:"collapse"`       // Collapse is the collapse policy: none, other or neighbor
+	SortByCount    bool           `yaml:"sortByCount"`    // SortByCount sorts strats by descending count
+	Measure        string         `yaml:"measure"`        // Measure is the measure of the size of a stratum
+	BalanceMeasure bool           `yaml:"balanceMeasure"` // BalanceMeasure balances the sample on Measure
+	Seed           int64          `yaml:"seed"`           // Seed is the seed of the sampling draws
+	HashKey        []string       `yaml:"hashKey"`        // HashKey are the columns rows are sampled by a hash of
+	Salt           string         `yaml:"salt"`           // Salt is the salt of the hash of HashKey
+	Splits         []Split        `yaml:"splits"`         // Splits are the splits the sample is divided into
+	Tiers          []int          `yaml:"tiers"`          // Tiers are the target totals of nested samples
+	ExcludeSource  string         `yaml:"excludeSource"`  // ExcludeSource is a table or query of keys never sampled
+	ExcludeKeys    []string       `yaml:"excludeKeys"`    // ExcludeKeys are the columns that identify excluded rows
+	TopUp          float64        `yaml:"topUp"`          // TopUp is the tolerance of the top-up pass, if > 0
+	TopUpKeys      []string       `yaml:"topUpKeys"`      // TopUpKeys are the columns that identify rows for the top-up
+	ClassLabel     string         `yaml:"classLabel"`     // ClassLabel is the label column whose classes are balanced
+	ClassRatio     []float64      `yaml:"classRatio"`     // ClassRatio are the relative sizes of the classes
+	PPSSize        string         `yaml:"ppsSize"`        // PPSSize is the size column of PPS sampling
+}
+
+// FieldConfig specifies a strat field and how its values are formed.  At most one of Bins, DateBucket and
+// TopN/Coverage may be given, and at most one of Map, MapTable and Dictionary.
+type FieldConfig struct {
+	Name       string            `yaml:"name"`       // Name is the name of the field in Query
+	Bins       []float64         `yaml:"bins"`       // Bins are the cut points that bin a numeric field
+	DateBucket string            `yaml:"dateBucket"` // DateBucket is day, week, month, quarter or year
+	TopN       int               `yaml:"topN"`       // TopN keeps the N most frequent values
+	Coverage   float64           `yaml:"coverage"`   // Coverage keeps the most frequent values covering this fraction
+	Map        map[string]string `yaml:"map"`        // Map recodes the values of the field
+	Default    string            `yaml:"default"`    // Default is the value for values not in Map or MapTable
+	MapTable   string            `yaml:"mapTable"`   // MapTable is a table that recodes the values of the field
+	MapFrom    string            `yaml:"mapFrom"`    // MapFrom is the field of MapTable with the values of the field
+	MapTo      string            `yaml:"mapTo"`      // MapTo is the field of MapTable with the recoded values
+	Dictionary string            `yaml:"dictionary"` // Dictionary is a dictionary that recodes the values of the field
+	DictAttr   string            `yaml:"dictAttr"`   // DictAttr is the attribute of Dictionary with the recoded values
+}
+
+// ParseSpec parses a YAML or JSON sampling spec.  Unknown keys are an error.
+func ParseSpec(data []byte) (*Spec, error) {
+	sp := &Spec{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	if e := dec.Decode(sp); e != nil {
+		return nil, fmt.Errorf("ParseSpec: %v", e)
+	}
+
+	return sp, nil
+}
+
+// FieldNames returns the names of the strat fields.
+func (sp *Spec) FieldNames() []string {
+	names := make([]string, len(sp.Fields))
+	for ind, fc := range sp.Fields {
+		names[ind] = fc.Name
+	}
+
+	return names
+}
+
+// check checks the spec for errors that don't require the source schema.
+func (sp *Spec) check() error {
+	switch {
+	case sp.Query == "":
+		return fmt.Errorf("(*Spec) Validate: query is required")
+	case sp.SampleTable == "" || sp.StratTable == "":
+		return fmt.Errorf("(*Spec) Validate: sampleTable and stratTable are required")
+	case sp.TargetTotal <= 0 && len(sp.Tiers) == 0 && sp.ClassLabel == "":
+		return fmt.Errorf("(*Spec) Validate: targetTotal must be positive")
+	case sp.SampleCap < 0.0 || sp.SampleCap > 1.0:
+		return fmt.Errorf("(*Spec) Validate: sampleCap must be in (0,1]")
+	case sp.MinCount < 0:
+		return fmt.Errorf("(*Spec) Validate: minCount must be non-negative")
+	case sp.Seed < 0:
+		return fmt.Errorf("(*Spec) Validate: seed must be non-negative")
+	case len(sp.Fields) == 0 && sp.ClassLabel == "":
+		return fmt.Errorf("(*Spec) Validate: must specify strat fields")
+	case sp.BalanceMeasure && sp.Measure == "":
+		return fmt.Errorf("(*Spec) Validate: balanceMeasure requires a measure")
+	}
+
+	if _, e := parseCollapse(sp.Collapse); e != nil {
+		return e
+	}
+
+	if e := (&Generator{}).Splits(sp.Splits...); e != nil {
+		return e
+	}
+
+	if e := (&Generator{}).Tiers(sp.Tiers...); e != nil {
+		return e
+	}
+
+	if e := (&Generator{}).Exclude(sp.ExcludeSource, sp.ExcludeKeys...); e != nil {
+		return e
+	}
+
+	if e := (&Generator{}).TopUp(sp.TopUp, sp.TopUpKeys...); e != nil {
+		return e
+	}
+
+	if e := (&Generator{}).BalanceClasses(sp.ClassLabel, sp.ClassRatio...); e != nil {
+		return e
+	}
+
+	if sp.TopUp > 0.0 && sp.TopUpKeys == nil && sp.HashKey == nil {
+		return fmt.Errorf("(*Spec) Validate: topUp requires topUpKeys or hashKey")
+	}
+
+	have := make(map[string]bool)
+	for _, fc := range sp.Fields {
+		if have[fc.Name] {
+			return fmt.Errorf("(*Spec) Validate: field %s is repeated", fc.Name)
+		}
+		have[fc.Name] = true
+
+		if e := fc.check(); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// check checks the field for errors that don't require the source schema.
+func (fc *FieldConfig) check() error {
+	if fc.Name == "" {
+		return fmt.Errorf("(*Spec) Validate: fields must have a name")
+	}
+
+	transforms, recodes := 0, 0
+	for _, set := range []bool{fc.Bins != nil, fc.DateBucket != "", fc.TopN > 0 || fc.Coverage > 0.0} {
+		if set {
+			transforms++
+		}
+	}
+
+	for _, set := range []bool{fc.Map != nil, fc.MapTable != "", fc.Dictionary != ""} {
+		if set {
+			recodes++
+		}
+	}
+
+	if transforms > 1 || recodes > 1 {
+		return fmt.Errorf("(*Spec) Validate: field %s has conflicting options", fc.Name)
+	}
+
+	for ind := 1; ind < len(fc.Bins); ind++ {
+		if fc.Bins[ind] <= fc.Bins[ind-1] {
+			return fmt.Errorf("(*Spec) Validate: bins for field %s must be increasing", fc.Name)
+		}
+	}
+
+	if _, e := parseGranularity(fc.DateBucket); e != nil {
+		return e
+	}
+
+	if fc.Coverage < 0.0 || fc.Coverage > 1.0 {
+		return fmt.Errorf("(*Spec) Validate: coverage for field %s must be in (0,1]", fc.Name)
+	}
+
+	if fc.MapTable != "" && (fc.MapFrom == "" || fc.MapTo == "") {
+		return fmt.Errorf("(*Spec) Validate: mapTable for field %s requires mapFrom and mapTo", fc.Name)
+	}
+
+	if fc.Dictionary != "" && fc.DictAttr == "" {
+		return fmt.Errorf("(*Spec) Validate: dictionary for field %s requires dictAttr", fc.Name)
+	}
+
+	return nil
+}
+
+// Validate checks the spec and checks the strat fields against the schema of Query.  Query is run with LIMIT 1 to
+// get its schema.  It returns the types of the strat fields in Query.
+func (sp *Spec) Validate(conn *chutils.Connect) ([]chutils.ChField, error) {
+	if e := sp.check(); e != nil {
+		return nil, e
+	}
+
+	rdr := s.NewReader(sp.Query, conn)
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		return nil, e
+	}
+
+	types := make([]chutils.ChField, len(sp.Fields))
+	for ind, fc := range sp.Fields {
+		_, fd, e := rdr.TableSpec().Get(fc.Name)
+		if e != nil {
+			return nil, fmt.Errorf("(*Spec) Validate: field %s is not in query", fc.Name)
+		}
+
+		base := fd.ChSpec.Base
+		recoded := fc.Map != nil || fc.MapTable != "" || fc.Dictionary != ""
+		switch {
+		case fc.Bins != nil && base != chutils.ChInt && base != chutils.ChFloat && !recoded:
+			return nil, fmt.Errorf("(*Spec) Validate: bins require a numeric field: %s", fc.Name)
+		case fc.Bins == nil && base == chutils.ChFloat && !recoded:
+			return nil, fmt.Errorf("(*Spec) Validate: cant stratify on type float without bins: %s", fc.Name)
+		case fc.DateBucket != "" && base != chutils.ChDate && !recoded:
+			return nil, fmt.Errorf("(*Spec) Validate: date bucketing requires a Date/DateTime field: %s", fc.Name)
+		case (fc.TopN > 0 || fc.Coverage > 0.0) && base != chutils.ChString && base != chutils.ChFixedString && !recoded:
+			return nil, fmt.Errorf("(*Spec) Validate: top-N/coverage bucketing requires a string field: %s", fc.Name)
+		}
+
+		types[ind] = fd.ChSpec
+	}
+
+	for _, key := range sp.HashKey {
+		if _, _, e := rdr.TableSpec().Get(key); e != nil {
+			return nil, fmt.Errorf("(*Spec) Validate: hash key %s is not in query", key)
+		}
+	}
+
+	for _, key := range sp.ExcludeKeys {
+		if _, _, e := rdr.TableSpec().Get(key); e != nil {
+			return nil, fmt.Errorf("(*Spec) Validate: exclusion key %s is not in query", key)
+		}
+	}
+
+	if sp.ClassLabel != "" {
+		if _, _, e := rdr.TableSpec().Get(sp.ClassLabel); e != nil {
+			return nil, fmt.Errorf("(*Spec) Validate: class label %s is not in query", sp.ClassLabel)
+		}
+	}
+
+	if sp.PPSSize != "" {
+		_, fd, e := rdr.TableSpec().Get(sp.PPSSize)
+		if e != nil {
+			return nil, fmt.Errorf("(*Spec) Validate: PPS size %s is not in query", sp.PPSSize)
+		}
+
+		if fd.ChSpec.Base != chutils.ChInt && fd.ChSpec.Base != chutils.ChFloat {
+			return nil, fmt.Errorf("(*Spec) Validate: PPS size %s must be numeric", sp.PPSSize)
+		}
+	}
+
+	for _, key := range sp.TopUpKeys {
+		if _, _, e := rdr.TableSpec().Get(key); e != nil {
+			return nil, fmt.Errorf("(*Spec) Validate: top-up key %s is not in query", key)
+		}
+	}
+
+	return types, nil
+}
+
+// Generator validates the spec and returns a *Generator configured by it.  CalcRates may be called without fields
+// to use the fields of the spec.
+func (sp *Spec) Generator(conn *chutils.Connect) (*Generator, error) {
+	types, e := sp.Validate(conn)
+	if e != nil {
+		return nil, e
+	}
+
+	gn := NewGenerator(sp.Query, sp.SampleTable, sp.StratTable, sp.TargetTotal, sp.SortByCount, conn)
+	gn.MinCount(sp.MinCount)
+	gn.SampleCap(sp.SampleCap)
+	gn.Measure(sp.Measure, sp.BalanceMeasure)
+	gn.Seed(sp.Seed)
+	gn.HashSample(sp.Salt, sp.HashKey...)
+
+	collapse, _ := parseCollapse(sp.Collapse)
+	gn.Collapse(collapse)
+
+	if e := gn.Splits(sp.Splits...); e != nil {
+		return nil, e
+	}
+
+	if e := gn.Tiers(sp.Tiers...); e != nil {
+		return nil, e
+	}
+
+	if e := gn.Exclude(sp.ExcludeSource, sp.ExcludeKeys...); e != nil {
+		return nil, e
+	}
+
+	if e := gn.TopUp(sp.TopUp, sp.TopUpKeys...); e != nil {
+		return nil, e
+	}
+
+	if e := gn.BalanceClasses(sp.ClassLabel, sp.ClassRatio...); e != nil {
+		return nil, e
+	}
+
+	if e := gn.PPS(sp.PPSSize); e != nil {
+		return nil, e
+	}
+
+	for ind, fc := range sp.Fields {
+		opts, e := fc.opts(types[ind])
+		if e != nil {
+			return nil, e
+		}
+
+		if len(opts) > 0 {
+			gn.FieldOpts(fc.Name, opts...)
+		}
+	}
+
+	gn.fields = sp.FieldNames()
+
+	return gn, nil
+}
+
+// opts returns the FieldOpts for the field.  ch is the type of the field in the source, which the keys of Map are
+// converted to.
+func (fc *FieldConfig) opts(ch chutils.ChField) ([]FieldOpt, error) {
+	opts := make([]FieldOpt, 0)
+	var deflt any = Other
+	if fc.Default != "" {
+		deflt = fc.Default
+	}
+
+	switch {
+	case fc.Map != nil:
+		m := make(map[any]any)
+		for k, v := range fc.Map {
+			key, e := parseValue(k, ch)
+			if e != nil {
+				return nil, fmt.Errorf("(*Spec) Generator: map key %s of field %s: %v", k, fc.Name, e)
+			}
+			m[key] = v
+		}
+		opts = append(opts, WithMap(m, deflt))
+	case fc.MapTable != "":
+		opts = append(opts, WithMapTable(fc.MapTable, fc.MapFrom, fc.MapTo, deflt))
+	case fc.Dictionary != "":
+		opts = append(opts, WithDictionary(fc.Dictionary, fc.DictAttr))
+	}
+
+	g, _ := parseGranularity(fc.DateBucket)
+	switch {
+	case fc.Bins != nil:
+		opts = append(opts, WithBins(fc.Bins...))
+	case g > 0:
+		opts = append(opts, WithDateBucket(g))
+	case fc.TopN > 0:
+		opts = append(opts, WithTopN(fc.TopN))
+	case fc.Coverage > 0.0:
+		opts = append(opts, WithCoverage(fc.Coverage))
+	}
+
+	return opts, nil
+}
+
+// parseValue converts str to a value of type ch.
+func parseValue(str string, ch chutils.ChField) (any, error) {
+	switch ch.Base {
+	case chutils.ChInt:
+		return strconv.ParseInt(str, 10, 64)
+	case chutils.ChFloat:
+		return strconv.ParseFloat(str, 64)
+	case chutils.ChDate:
+		return time.Parse("2006-01-02", str)
+	default:
+		return str, nil
+	}
+}
+
+// parseGranularity converts the name of a Granularity to its value.  The empty string is 0.
+func parseGranularity(name string) (Granularity, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return 0, nil
+	case "day":
+		return Day, nil
+	case "week":
+		return Week, nil
+	case "month":
+		return Month, nil
+	case "quarter":
+		return Quarter, nil
+	case "year":
+		return Year, nil
+	default:
+		return 0, fmt.Errorf("parseGranularity: unknown date bucket %s", name)
+	}
+}
+
+// parseCollapse converts the name of a CollapsePolicy to its value.  The empty string is CollapseNone.
+func parseCollapse(name string) (CollapsePolicy, error) {
+	for _, policy := range []CollapsePolicy{CollapseNone, CollapseOther, CollapseNeighbor} {
+		if strings.EqualFold(name, policy.String()) {
+			return policy, nil
+		}
+	}
+
+	if name == "" {
+		return CollapseNone, nil
+	}
+
+	return CollapseNone, fmt.Errorf("parseCollapse: unknown collapse policy %s", name)
+}
@@ -0,0 +1,70 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/invertedv/chutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSpec(t *testing.T) {
+	yml := `
+query: SELECT * FROM bk.loans
+sampleTable: tmp.sample
+stratTable: tmp.strat
+targetTotal: 1000
+collapse: other
+seed: 42
+fields:
+  - name: fico
+    bins: [600, 700]
+  - name: state
+    map: {CA: West, OR: West}
+splits:
+  - name: train
+    fraction: 0.75
+  - name: test
+    fraction: 0.25
+`
+	sp, e := ParseSpec([]byte(yml))
+	assert.Nil(t, e)
+	assert.Nil(t, sp.check())
+	assert.Equal(t, []string{"fico", "state"}, sp.FieldNames())
+	assert.Equal(t, []Split{{"train", 0.75}, {"test", 0.25}}, sp.Splits)
+
+	opts, e := sp.Fields[0].opts(chutils.ChField{Base: chutils.ChInt, Length: 32})
+	assert.Nil(t, e)
+	fs := newFieldSpec("fico", opts...)
+	assert.Equal(t, "toInt32(multiIf(fico < 600, 0, fico < 700, 1, 2))", fs.expr("fico"))
+	assert.Equal(t, "[600,700)", fs.format(int32(1)))
+	assert.Equal(t, ">=700", fs.format(int32(2)))
+
+	// JSON is also accepted
+	sp, e = ParseSpec([]byte(`{"query": "SELECT 1", "sampleTable": "a", "stratTable": "b", "targetTotal": 10,
+		"fields": [{"name": "x", "dateBucket": "month", "topN": 5}]}`))
+	assert.Nil(t, e)
+	assert.NotNil(t, sp.check())
+
+	_, e = ParseSpec([]byte("query: SELECT 1\ntargetTotl: 10\n"))
+	assert.NotNil(t, e)
+
+	gn := &Generator{}
+	assert.Nil(t, gn.Splits(Split{"train", 0.75}, Split{"valid", 0.15}, Split{"test", 0.1}))
+	assert.Equal(t, "multiIf(u < 0.75, 'train', u < 0.9, 'valid', 'test')", gn.splitCalc("u"))
+	assert.NotNil(t, gn.Splits(Split{"train", 0.75}))
+}
+
+func TestGenerator_draw(t *testing.T) {
+	gn := &Generator{}
+	assert.Equal(t, "rand32(rowNumberInAllBlocks()) / 4294967295.0", gn.draw("a", 0))
+
+	gn.Seed(42)
+	assert.Equal(t, "cityHash64(rowNumberInAllBlocks(), 42, 1) / 18446744073709551615.0", gn.draw("a", 1))
+
+	gn.HashSample("v1", "loanId")
+	assert.Equal(t, "cityHash64(a.loanId, 'v1') / 18446744073709551615.0", gn.draw("a", 0))
+	assert.Equal(t, "cityHash64(a.loanId, 'v1', 1) / 18446744073709551615.0", gn.draw("a", 1))
+
+	gn.HashSample("")
+	assert.Nil(t, gn.hashKey)
+}
@@ -0,0 +1,277 @@
+package sampler
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/invertedv/chutils"
+	s "github.com/invertedv/chutils/sql"
+)
+
+// Stat is a summary statistic calculated within each stratum.
+type Stat int
+
+const (
+	StatMean     Stat = 0 + iota // StatMean is the mean
+	StatMin                      // StatMin is the minimum
+	StatMax                      // StatMax is the maximum
+	StatStdDev                   // StatStdDev is the sample standard deviation
+	StatNullRate                 // StatNullRate is the fraction of rows that are null
+	StatQuantile                 // StatQuantile is a quantile
+)
+
+// Summary is a summary statistic of a column calculated within each stratum.
+type Summary struct {
+	Column   string    // Column is the column (or expression) summarized
+	Stat     Stat      // Stat is the statistic
+	Quantile float64   // Quantile is the quantile calculated if Stat is StatQuantile
+	Values   []float64 // Values are the values of the statistic in the same order as (*Strat).Table; nan if NULL
+
+	raw []float64 // values of the statistic for the strata before collapsing
+}
+
+// Name is the name of the summary, such as mean_upb or q50_upb.
+func (sm *Summary) Name() string {
+	col := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, sm.Column)
+
+	return fmt.Sprintf("%s_%s", sm.Stat.prefix(sm.Quantile), col)
+}
+
+// agg returns the ClickHouse aggregate that calculates the summary.  The aggregate is a Float64 that is nan if the
+// statistic is NULL.
+func (sm *Summary) agg() string {
+	return fmt.Sprintf("toFloat64(ifNull(%s, nan))", sm.calc())
+}
+
+// calc returns the ClickHouse aggregate of the statistic.
+func (sm *Summary) calc() string {
+	switch sm.Stat {
+	case StatMean:
+		return fmt.Sprintf("avg(toFloat64(%s))", sm.Column)
+	case StatMin:
+		return fmt.Sprintf("toFloat64(min(%s))", sm.Column)
+	case StatMax:
+		return fmt.Sprintf("toFloat64(max(%s))", sm.Column)
+	case StatStdDev:
+		return fmt.Sprintf("stddevSamp(toFloat64(%s))", sm.Column)
+	case StatNullRate:
+		return fmt.Sprintf("countIf(isNull(%s)) / count(*)", sm.Column)
+	case StatQuantile:
+		return fmt.Sprintf("toFloat64(quantile(%v)(%s))", sm.Quantile, sm.Column)
+	default:
+		return ""
+	}
+}
+
+func (st Stat) prefix(q float64) string {
+	switch st {
+	case StatMean:
+		return "mean"
+	case StatMin:
+		return "min"
+	case StatMax:
+		return "max"
+	case StatStdDev:
+		return "stdDev"
+	case StatNullRate:
+		return "nullRate"
+	case StatQuantile:
+		return fmt.Sprintf("q%s", strings.ReplaceAll(fmt.Sprintf("%v", 100*q), ".", "_"))
+	default:
+		return "unknown"
+	}
+}
+
+// Summarize adds summary statistics of column to be calculated within each stratum by Make.  column may be an
+// expression.  Use SummarizeQuantiles for quantiles.
+func (strt *Strat) Summarize(column string, stats ...Stat) {
+	strt.summaries = append(strt.summaries, newSummaries(column, stats...)...)
+}
+
+// SummarizeQuantiles adds the quantiles qs of column to be calculated within each stratum by Make.
+func (strt *Strat) SummarizeQuantiles(column string, qs ...float64) {
+	strt.summaries = append(strt.summaries, newQuantiles(column, qs...)...)
+}
+
+// Summaries returns the summary statistics calculated by Make.
+func (strt *Strat) Summaries() []*Summary {
+	return strt.summaries
+}
+
+// Summarize adds summary statistics of column to be calculated within each stratum by CalcRates.
+// The statistics are saved to stratTable.
+func (gn *Generator) Summarize(column string, stats ...Stat) {
+	gn.summaries = append(gn.summaries, newSummaries(column, stats...)...)
+	gn.reset()
+}
+
+// SummarizeQuantiles adds the quantiles qs of column to be calculated within each stratum by CalcRates.
+// The quantiles are saved to stratTable.
+func (gn *Generator) SummarizeQuantiles(column string, qs ...float64) {
+	gn.summaries = append(gn.summaries, newQuantiles(column, qs...)...)
+	gn.reset()
+}
+
+func newSummaries(column string, stats ...Stat) []*Summary {
+	sms := make([]*Summary, 0)
+	for _, st := range stats {
+		if st == StatQuantile {
+			continue
+		}
+		sms = append(sms, &Summary{Column: column, Stat: st})
+	}
+
+	return sms
+}
+
+func newQuantiles(column string, qs ...float64) []*Summary {
+	sms := make([]*Summary, len(qs))
+	for ind, q := range qs {
+		sms[ind] = &Summary{Column: column, Stat: StatQuantile, Quantile: q}
+	}
+
+	return sms
+}
+
+// copySummaries returns the definitions of the summaries in sms without values.
+func copySummaries(sms []*Summary) []*Summary {
+	cp := make([]*Summary, len(sms))
+	for ind, sm := range sms {
+		cp[ind] = &Summary{Column: sm.Column, Stat: sm.Stat, Quantile: sm.Quantile}
+	}
+
+	return cp
+}
+
+// summaryCalcs returns the aggregates for the summaries for a query.
+func (strt *Strat) summaryCalcs() string {
+	calcs := make([]string, len(strt.summaries))
+	for ind, sm := range strt.summaries {
+		calcs[ind] = fmt.Sprintf("%s AS %s", sm.agg(), sm.Name())
+	}
+
+	return strings.Join(calcs, ", ")
+}
+
+// summarizeGroups calculates the measure and the summaries for collapsed strata.  Neither can, in general, be
+// calculated from the values of the strata that are collapsed -- think of an average or a quantile -- so they're
+// calculated from the data.
+func (strt *Strat) summarizeGroups() error {
+	for _, sm := range strt.summaries {
+		sm.raw = sm.Values
+		sm.Values = make([]float64, len(strt.keys))
+	}
+
+	if !strt.collapsed() || (len(strt.summaries) == 0 && strt.measure == "") {
+		for _, sm := range strt.summaries {
+			copy(sm.Values, sm.raw)
+		}
+
+		return nil
+	}
+
+	calcs := make([]string, 0)
+	if strt.measure != "" {
+		calcs = append(calcs, strt.measureCalc())
+	}
+
+	if len(strt.summaries) > 0 {
+		calcs = append(calcs, strt.summaryCalcs())
+	}
+
+	qry := fmt.Sprintf("SELECT g.grp AS grp, %s FROM (%s) AS a JOIN (%s) AS g ON %s GROUP BY grp",
+		strings.Join(calcs, ", "), strt.source(strt.fields...), strt.groupQuery(), strt.groupJoins("a", "g"))
+
+	rdr := s.NewReader(qry, strt.conn)
+
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		return e
+	}
+
+	rows, _, e := rdr.Read(0, false)
+	if e != nil {
+		return e
+	}
+
+	for _, row := range rows {
+		grp, ok := toFloat(row[0])
+		if !ok {
+			continue
+		}
+
+		col := 1
+		if strt.measure != "" {
+			val, e := asFloat(row[col])
+			if e != nil {
+				return fmt.Errorf("(*Strat) Make: measure %s: %v", strt.measure, e)
+			}
+			strt.measures[int(grp)] = val
+			col++
+		}
+
+		for ind, sm := range strt.summaries {
+			val, e := asFloat(row[col+ind])
+			if e != nil {
+				return fmt.Errorf("(*Strat) Make: summary %s: %v", sm.Name(), e)
+			}
+			sm.Values[int(grp)] = val
+		}
+	}
+
+	return nil
+}
+
+// groupQuery returns a query of the raw strata, in columns grpKey1, ..., and the collapsed stratum each is in, grp.
+func (strt *Strat) groupQuery() string {
+	rows := make([]string, len(strt.rawKeys))
+	for ind, key := range strt.rawKeys {
+		vals := make([]string, 0, len(key)+1)
+		for _, k := range key {
+			vals = append(vals, literal(k))
+		}
+		rows[ind] = fmt.Sprintf("(%s)", strings.Join(append(vals, fmt.Sprintf("%d", strt.group[ind])), ", "))
+	}
+
+	cols := make([]string, 0, len(strt.fields)+1)
+	for ind := range strt.fields {
+		cols = append(cols, fmt.Sprintf("tupleElement(t, %d) AS grpKey%d", ind+1, ind+1))
+	}
+	cols = append(cols, fmt.Sprintf("tupleElement(t, %d) AS grp", len(strt.fields)+1))
+
+	return fmt.Sprintf("SELECT %s FROM (SELECT arrayJoin([%s]) AS t)", strings.Join(cols, ", "), strings.Join(rows, ", "))
+}
+
+// groupJoins returns the conditions that join the source aliased as src to groupQuery aliased as grp.
+func (strt *Strat) groupJoins(src, grp string) string {
+	joins := make([]string, len(strt.fields))
+	for ind, f := range strt.fields {
+		joins[ind] = fmt.Sprintf("%s.%s = %s.grpKey%d", src, f, grp, ind+1)
+	}
+
+	return strings.Join(joins, " AND ")
+}
+
+// asFloat converts x, a value of a Float64 column, to float64.  NULLs are nan.
+func asFloat(x any) (float64, error) {
+	switch val := x.(type) {
+	case nil:
+		return math.NaN(), nil
+	case *float64:
+		if val == nil {
+			return math.NaN(), nil
+		}
+		return *val, nil
+	}
+
+	if val, ok := toFloat(x); ok {
+		return val, nil
+	}
+
+	return 0, fmt.Errorf("cannot convert %v of type %T to float64", x, x)
+}
@@ -0,0 +1,56 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary_Name(t *testing.T) {
+	strt := &Strat{}
+	strt.Summarize("origFico", StatMean, StatNullRate)
+	strt.SummarizeQuantiles("upb/1000", 0.5, 0.975)
+
+	names := make([]string, 0)
+	for _, sm := range strt.Summaries() {
+		names = append(names, sm.Name())
+	}
+	assert.Equal(t, []string{"mean_origFico", "nullRate_origFico", "q50_upb_1000", "q97_5_upb_1000"}, names)
+	assert.Equal(t, "toFloat64(ifNull(toFloat64(quantile(0.5)(upb/1000)), nan))", strt.summaries[2].agg())
+}
+
+func TestAsFloat(t *testing.T) {
+	x := 2.5
+	for ind, v := range []any{2.5, &x, uint64(2), int32(-2)} {
+		val, e := asFloat(v)
+		assert.Nil(t, e)
+		assert.Equal(t, []float64{2.5, 2.5, 2, -2}[ind], val)
+	}
+
+	var np *float64
+	for _, v := range []any{nil, np} {
+		val, e := asFloat(v)
+		assert.Nil(t, e)
+		assert.True(t, math.IsNaN(val))
+	}
+
+	_, e := asFloat("2.5")
+	assert.NotNil(t, e)
+}
+
+func TestStrat_groupQuery(t *testing.T) {
+	strt := &Strat{
+		fields:  []string{"state", "vintage"},
+		rawKeys: [][]any{{"TX", int32(2020)}, {"VT", int32(2020)}, {"WY", nil}},
+		group:   []int{0, 1, 1},
+	}
+
+	assert.Equal(t, "SELECT tupleElement(t, 1) AS grpKey1, tupleElement(t, 2) AS grpKey2, tupleElement(t, 3) AS grp "+
+		"FROM (SELECT arrayJoin([('TX', 2020, 0), ('VT', 2020, 1), ('WY', NULL, 1)]) AS t)", strt.groupQuery())
+	assert.Equal(t, "a.state = g.grpKey1 AND a.vintage = g.grpKey2", strt.groupJoins("a", "g"))
+
+	// the measure of a collapsed stratum is calculated from its rows, since it needn't be additive
+	strt.measure = "avg(upb)"
+	assert.Equal(t, "toFloat64(ifNull(avg(upb), nan)) AS measure", strt.measureCalc())
+}
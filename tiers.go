@@ -0,0 +1,123 @@
+package sampler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Tiers sets the target totals of nested samples, such as 10k, 100k and 1M rows.  Each sample is a subset of the
+// larger ones.  The largest becomes the target total of the Generator.
+//
+// CalcRates finds the rates of each tier as it would for a single sample, then raises each tier's rate in a stratum to
+// that of the next smaller tier, if need be, so the rates increase with the tier.  Hence a tier may be a little larger
+// than its target.
+//
+// sampleTable has a column, tier, that is the target total of the smallest tier the row is in.  The sample of a tier
+// is therefore the rows with tier no larger than its target.  MakeTiers makes a table for each tier.
+// Calling Tiers with no targets turns off tiers and restores the target total the Generator had before.
+func (gn *Generator) Tiers(targets ...int) error {
+	if len(targets) == 0 {
+		if gn.tiers != nil {
+			gn.tiers, gn.targetTotal = nil, gn.tierBase
+			gn.reset()
+		}
+
+		return nil
+	}
+
+	tiers := append([]int{}, targets...)
+	sort.Ints(tiers)
+
+	for ind, t := range tiers {
+		if t <= 0 {
+			return fmt.Errorf("(*Generator) Tiers: targets must be positive")
+		}
+
+		if ind > 0 && t == tiers[ind-1] {
+			return fmt.Errorf("(*Generator) Tiers: duplicate target %d", t)
+		}
+	}
+
+	if gn.tiers == nil {
+		gn.tierBase = gn.targetTotal
+	}
+
+	gn.tiers = tiers
+	gn.targetTotal = tiers[len(tiers)-1]
+	gn.reset()
+
+	return nil
+}
+
+// MakeTiers makes sampleTable and then a table for each tier, <sampleTable>_<target>, which doesn't have the tier
+// column.  The names of the tier tables are returned, smallest first.
+// timeOut is the query time out in minutes.
+func (gn *Generator) MakeTiers(timeOut int64) ([]string, error) {
+	if gn.tiers == nil {
+		return nil, fmt.Errorf("(*Generator) MakeTiers: no tiers set")
+	}
+
+	if e := gn.MakeTable(timeOut); e != nil {
+		return nil, e
+	}
+
+	tables := make([]string, 0)
+	for _, t := range gn.tiers {
+		table := fmt.Sprintf("%s_%d", gn.sampleTable, t)
+		qry := fmt.Sprintf("SELECT * EXCEPT (tier) FROM %s WHERE tier <= %d", gn.sampleTable, t)
+		if e := makeTable(qry, table, gn.conn); e != nil {
+			return nil, e
+		}
+
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+// TierRates returns the sampling rates of each tier.  TierRates()[k] are the rates of the kth smallest tier, in the
+// same order as Strats.
+func (gn *Generator) TierRates() [][]float64 {
+	return gn.tierRates
+}
+
+// nestedRates returns the sampling rates of each of the targets, which are increasing.  The rates of a stratum are
+// non-decreasing across the targets.
+func nestedRates(sizes []float64, targets []int, sampleCap float64) [][]float64 {
+	rates := make([][]float64, len(targets))
+	for ind, t := range targets {
+		rates[ind], _, _ = allocate(sizes, t, sampleCap)
+
+		if ind == 0 {
+			continue
+		}
+
+		for st := 0; st < len(sizes); st++ {
+			rates[ind][st] = math.Max(rates[ind][st], rates[ind-1][st])
+		}
+	}
+
+	return rates
+}
+
+// tierCalc returns the expression that gives the tier of a row given its draw u.  The rates of the tiers are the
+// columns tierRate1, tierRate2, ... of the strats table aliased as b.
+func (gn *Generator) tierCalc(u string) string {
+	conds := make([]string, 0)
+	for ind, t := range gn.tiers {
+		if ind == len(gn.tiers)-1 {
+			conds = append(conds, fmt.Sprintf("%d", t))
+			break
+		}
+
+		conds = append(conds, fmt.Sprintf("%s < b.tierRate%d, %d", u, ind+1, t))
+	}
+
+	if len(conds) == 1 {
+		return fmt.Sprintf("toInt64(%s)", conds[0])
+	}
+
+	return fmt.Sprintf("toInt64(multiIf(%s))", strings.Join(conds, ", "))
+}
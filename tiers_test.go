@@ -0,0 +1,43 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_Tiers(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	assert.NotNil(t, gn.Tiers(100, 0))
+	assert.NotNil(t, gn.Tiers(100, 100))
+
+	assert.Nil(t, gn.Tiers(10000, 100, 1000))
+	assert.Equal(t, []int{100, 1000, 10000}, gn.tiers)
+	assert.Equal(t, 10000, gn.targetTotal)
+
+	assert.Equal(t, "toInt64(multiIf(u < b.tierRate1, 100, u < b.tierRate2, 1000, 10000))", gn.tierCalc("u"))
+
+	// changing the tiers drops the rates and turning them off restores the target total
+	gn.sampleRate = []float64{0.5}
+	assert.Nil(t, gn.Tiers(200, 2000))
+	assert.Nil(t, gn.sampleRate)
+	assert.Equal(t, 2000, gn.targetTotal)
+
+	assert.Nil(t, gn.Tiers())
+	assert.Nil(t, gn.tiers)
+	assert.Equal(t, 1000, gn.targetTotal)
+}
+
+func TestNestedRates(t *testing.T) {
+	sizes := []float64{100, 1000, 10000}
+	rates := nestedRates(sizes, []int{300, 3000}, 1.0)
+	assert.Len(t, rates, 2)
+
+	for st := range sizes {
+		assert.GreaterOrEqual(t, rates[1][st], rates[0][st])
+	}
+
+	// the smallest stratum is exhausted by the larger tier
+	assert.InDelta(t, 1.0, rates[1][0], 0.0001)
+	assert.InDelta(t, 0.1, rates[0][1], 0.0001)
+}
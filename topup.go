@@ -0,0 +1,147 @@
+package sampler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxTopUps is the maximum number of top-up passes MakeTable makes.
+const maxTopUps = 5
+
+// TopUp turns on a second pass of MakeTable that tops up strata whose sample fell short of the allocation.  Since rows
+// are drawn at random, a stratum's sample may be noticeably smaller than its expected size, particularly if the
+// stratum is small.
+//
+// A stratum is short if its sample is less than (1-tolerance) times its expected size.  Rows of short strata that
+// aren't already in sampleTable are drawn at the rate needed to make up the shortfall.  This is repeated until no
+// stratum is short or no short stratum has rows left, up to 5 passes.
+//
+// keys are the columns that identify a row, so rows already sampled are not drawn again.  If keys aren't given, the
+// columns of HashSample are used.  A tolerance of 0 turns off the top-up.  Class-balanced samples can't be topped up,
+// since the weight of a row drawn by the top-up isn't 1/(sampling rate).
+func (gn *Generator) TopUp(tolerance float64, keys ...string) error {
+	if tolerance < 0.0 || tolerance >= 1.0 {
+		return fmt.Errorf("(*Generator) TopUp: tolerance must be in [0,1)")
+	}
+
+	if tolerance > 0.0 && gn.classLabel != "" {
+		return fmt.Errorf("(*Generator) TopUp: cannot top up class-balanced samples")
+	}
+
+	if tolerance > 0.0 && (gn.tiers != nil || gn.ppsSize != "") {
+		return fmt.Errorf("(*Generator) TopUp: cannot top up tiers or PPS samples")
+	}
+
+	gn.topUpTol, gn.topUpKeys = tolerance, keys
+
+	return nil
+}
+
+// TopUpObs returns the number of rows added to sampleTable by the top-up pass.
+func (gn *Generator) TopUpObs() int {
+	return gn.topUpObs
+}
+
+// topUpCheck returns an error if the top-up is on and can't be run with the other settings of the Generator.  It's
+// checked before sampleTable is built.
+func (gn *Generator) topUpCheck() error {
+	if gn.topUpTol == 0.0 {
+		return nil
+	}
+
+	if gn.topUpKeys == nil && gn.hashKey == nil {
+		return fmt.Errorf("(*Generator) TopUp: must specify key columns")
+	}
+
+	if gn.tiers != nil || gn.ppsSize != "" {
+		return fmt.Errorf("(*Generator) TopUp: cannot top up tiers or PPS samples")
+	}
+
+	return nil
+}
+
+// topUp runs the top-up passes on sampleTable.  The sampleStrats must be current.
+func (gn *Generator) topUp() error {
+	keys := gn.topUpKeys
+	if keys == nil {
+		keys = gn.hashKey
+	}
+
+	table := gn.stratTable + "_topup"
+	gn.topUpObs = 0
+	before := gn.actCaptured
+
+	for pass := 0; pass < maxTopUps; pass++ {
+		rates, short := gn.topUpRates()
+		if !short {
+			break
+		}
+
+		if e := gn.execute(fmt.Sprintf("DROP TABLE IF EXISTS %s", table),
+			fmt.Sprintf("CREATE TABLE %s AS %s", table, gn.stratTable)); e != nil {
+			return e
+		}
+
+		if e := gn.insertStrats(table, rates); e != nil {
+			return e
+		}
+
+		qry := fmt.Sprintf("INSERT INTO %s %s", gn.sampleTable, gn.topUpQuery(table, pass, keys))
+		if e := gn.conn.Execute(qry); e != nil {
+			return e
+		}
+
+		if e := gn.makeSampleStrats(); e != nil {
+			return e
+		}
+	}
+
+	gn.topUpObs = gn.actCaptured - before
+
+	return gn.conn.Execute(fmt.Sprintf("DROP TABLE IF EXISTS %s", table))
+}
+
+// topUpRates returns the rates at which the rows not yet sampled are drawn to make up the shortfall of each stratum.
+// short is false if no stratum both is short and has rows left to draw.
+func (gn *Generator) topUpRates() (rates []float64, short bool) {
+	act := make(map[string]uint64)
+	for ind, key := range gn.sampleStrats.keys {
+		act[keyString(key)] = gn.sampleStrats.count[ind]
+	}
+
+	rates = make([]float64, len(gn.strats.keys))
+	for ind, key := range gn.strats.keys {
+		exp := gn.sampleRate[ind] * float64(gn.strats.count[ind])
+		a := float64(act[keyString(key)])
+		left := float64(gn.strats.count[ind]) - a
+
+		if a >= (1.0-gn.topUpTol)*exp || left <= 0 {
+			continue
+		}
+
+		rates[ind] = (exp - a) / left
+		if rates[ind] > 1.0 {
+			rates[ind] = 1.0
+		}
+		short = true
+	}
+
+	return rates, short
+}
+
+// topUpQuery returns the query that draws the rows not in sampleTable at the rates in table.  keys identify the rows.
+func (gn *Generator) topUpQuery(table string, pass int, keys []string) string {
+	sel := "a.*"
+	if gn.splits != nil {
+		sel = fmt.Sprintf("a.*,\n  %s AS split", gn.splitCalc(gn.draw("a", 1)))
+	}
+
+	k := strings.Join(keys, ", ")
+	src := fmt.Sprintf("SELECT * FROM (%s) WHERE (%s) NOT IN (SELECT %s FROM %s)", gn.available(gn.Query), k, k, gn.sampleTable)
+
+	qry := fmt.Sprintf("SELECT\n  %s\nFROM\n  (%s) AS a\nJOIN\n  %s AS b\n ON \n", sel, src, table)
+	qry = fmt.Sprintf("%s %s", qry, gn.joins("a", "b"))
+
+	// each pass needs draws independent of those of MakeTable and earlier passes
+	return fmt.Sprintf("%s WHERE %s < b.sampleRate\n", qry, gn.draw("a", pass+3))
+}
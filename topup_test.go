@@ -0,0 +1,53 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_topUp(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	assert.NotNil(t, gn.TopUp(1.0))
+	assert.Nil(t, gn.TopUp(0.05, "loanId"))
+
+	gn.strats = NewStrat(gn.Query, nil, false)
+	gn.strats.fields = []string{"state"}
+	gn.strats.keys, gn.strats.count = [][]any{{"TX"}, {"VT"}, {"WY"}}, []uint64{10000, 1000, 100}
+	gn.sampleRate = []float64{0.05, 0.5, 1.0}
+	gn.sampleStrats = NewStrat(gn.Query, nil, false)
+	gn.sampleStrats.keys, gn.sampleStrats.count = [][]any{{"TX"}, {"VT"}, {"WY"}}, []uint64{490, 400, 100}
+
+	// TX is within tolerance, VT is short and WY has no rows left
+	rates, short := gn.topUpRates()
+	assert.True(t, short)
+	assert.Equal(t, []float64{0, 100.0 / 600.0, 0}, rates)
+
+	gn.sampleStrats.count[1] = 500
+	_, short = gn.topUpRates()
+	assert.False(t, short)
+
+	qry := gn.topUpQuery("strt_topup", 1, []string{"loanId"})
+	assert.Contains(t, qry, "WHERE (loanId) NOT IN (SELECT loanId FROM smp)")
+	assert.Contains(t, qry, "strt_topup AS b")
+	assert.Contains(t, qry, "rand32(rowNumberInAllBlocks() + 4) / 4294967295.0 < b.sampleRate")
+}
+
+func TestGenerator_topUpCheck(t *testing.T) {
+	gn := NewGenerator("SELECT * FROM src", "smp", "strt", 1000, false, nil)
+	assert.Nil(t, gn.TopUp(0.05))
+	assert.NotNil(t, gn.topUpCheck())
+	assert.NotNil(t, gn.CalcRates("state"))
+
+	gn.HashSample("", "loanId")
+	assert.Nil(t, gn.topUpCheck())
+
+	assert.Nil(t, gn.Tiers(100, 200))
+	assert.NotNil(t, gn.TopUp(0.05))
+	assert.NotNil(t, gn.topUpCheck())
+	assert.NotNil(t, gn.CalcRates("state"))
+
+	// CalcRates and MakeTable catch a conflict before touching the DB
+	gn.strats = NewStrat(gn.Query, nil, false)
+	assert.NotNil(t, gn.MakeTable(0))
+}